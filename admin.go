@@ -0,0 +1,280 @@
+package limnet
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tangtaoit/limnet/pkg/limlog"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ConnInfo 连接信息（管理接口使用）
+type ConnInfo struct {
+	ID               int64     `json:"id"`
+	Addr             string    `json:"addr"`
+	Transport        Transport `json:"transport"`
+	Loop             int       `json:"loop"` // 所属eventloop的下标 websocket连接为-1
+	InboundBuffered  int       `json:"inbound_buffered"`
+	OutboundBuffered int       `json:"outbound_buffered"`
+	BufferMemory     int       `json:"buffer_memory"`         // 输入输出buffer占用的内存
+	StalledSeconds   float64   `json:"write_stalled_seconds"` // 输出积压时距最后一次写出进展的时长
+	IdleSeconds      float64   `json:"idle_seconds"`
+}
+
+// LoopInfo eventloop信息（管理接口使用）
+type LoopInfo struct {
+	Index        int   `json:"index"`
+	Handlers     int64 `json:"handlers"`
+	JobQueueLen  int   `json:"job_queue_len"`
+	JobsExecuted int64 `json:"jobs_executed"`
+	Wakeups      int64 `json:"wakeups"`
+	Panics       int64 `json:"panics"`
+}
+
+// AdminServer 管理接口服务
+type AdminServer struct {
+	limlog.Log
+	lnet *LIMNet
+	srv  *http.Server
+}
+
+// NewAdminServer 创建管理接口服务
+func NewAdminServer(lnet *LIMNet) *AdminServer {
+	return &AdminServer{
+		Log:  limlog.NewLIMLog("AdminServer"),
+		lnet: lnet,
+	}
+}
+
+// ErrAdminTokenRequired 管理接口没有独立的监听地址（挂载到websocket服务上）时必须设置token
+var ErrAdminTokenRequired = errors.New("管理接口挂载到websocket服务上必须设置token")
+
+// Mount 将管理接口挂载到mux上
+func (s *AdminServer) Mount(mux *http.ServeMux) {
+	s.mount(mux, nil)
+}
+
+// mount 将管理接口挂载到mux上，wrap不为nil时包装每个接口（例如挂载到websocket服务上时的ip访问控制）
+func (s *AdminServer) mount(mux *http.ServeMux, wrap func(http.Handler) http.Handler) {
+	handle := func(pattern string, h http.HandlerFunc) {
+		handler := s.auth(h)
+		if wrap != nil {
+			handler = wrap(handler)
+		}
+		mux.Handle(pattern, handler)
+	}
+	handle("/debug/limnet/conns", s.handleConns)
+	handle("/debug/limnet/conns/close", s.handleCloseConn)
+	handle("/debug/limnet/loops", s.handleLoops)
+	handle("/debug/limnet/options", s.handleOptions)
+	handle("/debug/limnet/loglevel", s.handleLogLevel)
+	handle("/debug/limnet/metrics", s.lnet.MetricsHandler().ServeHTTP)
+	handle("/debug/pprof/", pprof.Index)
+	handle("/debug/pprof/cmdline", pprof.Cmdline)
+	handle("/debug/pprof/profile", pprof.Profile)
+	handle("/debug/pprof/symbol", pprof.Symbol)
+	handle("/debug/pprof/trace", pprof.Trace)
+}
+
+// Start 开启独立的管理接口监听，监听失败直接返回错误
+func (s *AdminServer) Start() error {
+	ln, err := net.Listen("tcp", s.lnet.opts.AdminAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	s.Mount(mux)
+	s.srv = &http.Server{Addr: s.lnet.opts.AdminAddr, Handler: mux}
+	go func() {
+		err := s.srv.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.Error("管理接口异常退出！", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// Stop Stop
+func (s *AdminServer) Stop() error {
+	if s.srv == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.srv.Shutdown(ctx)
+}
+
+// auth 校验token 只支持 Authorization: Bearer <token>（放在url里会被记录到访问日志、代理和浏览器历史里）
+func (s *AdminServer) auth(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := s.lnet.opts.AdminToken; token != "" {
+			header := r.Header.Get("Authorization")
+			if !strings.HasPrefix(header, "Bearer ") ||
+				subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	})
+}
+
+func (s *AdminServer) handleConns(w http.ResponseWriter, r *http.Request) {
+	infos := s.lnet.connInfos(time.Second)
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	writeJSON(w, infos)
+}
+
+func (s *AdminServer) handleCloseConn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	conn := s.lnet.GetConn(id)
+	if conn == nil {
+		http.Error(w, "conn not found", http.StatusNotFound)
+		return
+	}
+	if err = conn.Close(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.Info("管理接口关闭连接", zap.Int64("id", id))
+	writeJSON(w, map[string]interface{}{"id": id, "closed": true})
+}
+
+func (s *AdminServer) handleLoops(w http.ResponseWriter, r *http.Request) {
+	infos := make([]LoopInfo, 0, len(s.lnet.connectLoops))
+	for i, loop := range s.lnet.connectLoops {
+		stats := loop.Stats()
+		infos = append(infos, LoopInfo{
+			Index:        i,
+			Handlers:     stats.Handlers,
+			JobQueueLen:  stats.JobQueueLen,
+			JobsExecuted: stats.JobsExecuted,
+			Wakeups:      stats.Wakeups,
+			Panics:       stats.Panics,
+		})
+	}
+	writeJSON(w, infos)
+}
+
+func (s *AdminServer) handleOptions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.lnet.opts)
+}
+
+// handleLogLevel GET获取当前日志等级 POST ?level=debug 修改日志等级
+func (s *AdminServer) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(r.URL.Query().Get("level"))); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limlog.SetLevel(level)
+		s.Info("管理接口修改日志等级", zap.String("level", level.String()))
+	}
+	writeJSON(w, map[string]string{"level": limlog.GetLevel().String()})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// connInfos 获取所有连接的信息 tcp连接的buffer只能在所属eventloop里读取，所以需要投递到各个eventloop里收集
+func (l *LIMNet) connInfos(timeout time.Duration) []ConnInfo {
+	var (
+		mu    sync.Mutex
+		infos []ConnInfo
+		wg    sync.WaitGroup
+	)
+	for i := range l.connectLoops {
+		loop := l.connectLoops[i]
+		wg.Add(1)
+		_ = loop.Trigger(func() error {
+			defer wg.Done()
+			l.conns.Range(func(key, value interface{}) bool {
+				c, ok := value.(*TCPConn)
+				if !ok || c.loop != loop || !c.connected.Get() {
+					return true
+				}
+				info := ConnInfo{
+					ID:               c.id,
+					Addr:             c.addr,
+					Transport:        TransportTCP,
+					Loop:             c.loopIndex,
+					InboundBuffered:  c.BufferLength(),
+					OutboundBuffered: c.outboundBuffer.Length(),
+					BufferMemory:     c.bufferMemory(),
+					IdleSeconds:      c.IdleTime().Seconds(),
+				}
+				if !c.outboundBuffer.IsEmpty() {
+					info.StalledSeconds = time.Since(c.writeStalledAt).Seconds()
+				}
+				mu.Lock()
+				infos = append(infos, info)
+				mu.Unlock()
+				return true
+			})
+			return nil
+		})
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		l.Warn("收集连接信息超时，返回部分结果")
+	}
+
+	l.conns.Range(func(key, value interface{}) bool {
+		c, ok := value.(*WSConn)
+		if !ok {
+			return true
+		}
+		info := ConnInfo{
+			ID:           c.id,
+			Addr:         c.addr,
+			Transport:    TransportWS,
+			Loop:         -1,
+			BufferMemory: int(c.memory.Get()),
+			IdleSeconds:  c.IdleTime().Seconds(),
+		}
+		if since := c.writingSince.Get(); since > 0 {
+			info.OutboundBuffered = int(c.writingBytes.Get())
+			info.StalledSeconds = time.Since(time.Unix(0, since)).Seconds()
+		}
+		mu.Lock()
+		infos = append(infos, info)
+		mu.Unlock()
+		return true
+	})
+
+	mu.Lock()
+	result := make([]ConnInfo, len(infos))
+	copy(result, infos)
+	mu.Unlock()
+	return result
+}
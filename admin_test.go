@@ -0,0 +1,192 @@
+package limnet
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tangtaoit/limnet/pkg/acl"
+	"github.com/tangtaoit/limnet/pkg/limlog"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestAdminOnWSMux(t *testing.T) {
+	if _, err := NewServer(&TestHandler{}, WithAddr("tcp://127.0.0.1:17121"), WithWSAddr("127.0.0.1:17122"), WithAdmin("", "")); err != ErrAdminTokenRequired {
+		t.Fatalf("expect ErrAdminTokenRequired but got %v", err)
+	}
+
+	l, err := NewServer(&TestHandler{}, WithAddr("tcp://127.0.0.1:17121"), WithWSAddr("127.0.0.1:17122"),
+		WithAdmin("", "secret"), WithMetricsPath("/metrics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Stop()
+
+	get := func(path string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:17122"+path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Close = true
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get("/debug/limnet/loops"); code != http.StatusOK {
+		t.Fatalf("expect 200 but got %d", code)
+	}
+	if code := get("/metrics"); code != http.StatusOK {
+		t.Fatalf("expect 200 but got %d", code)
+	}
+
+	// 挂载到websocket服务上的管理接口和指标也经过ip访问控制
+	deny, err := acl.New(nil, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetACL(deny)
+	if code := get("/debug/limnet/loops"); code != http.StatusForbidden {
+		t.Fatalf("expect 403 but got %d", code)
+	}
+	if code := get("/metrics"); code != http.StatusForbidden {
+		t.Fatalf("expect 403 but got %d", code)
+	}
+}
+
+// adminTestServer 挂载了管理接口（token为secret）的http服务
+func adminTestServer(t *testing.T, h EventHandler) (*LIMNet, string, *httptest.Server) {
+	t.Helper()
+	l, addr := startServer(t, h, WithUnPacket(lineUnPacket), WithAdmin("", "secret"))
+	mux := http.NewServeMux()
+	l.admin.Mount(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return l, addr, srv
+}
+
+// adminDo 请求管理接口，auth不为空时作为Authorization请求头
+func adminDo(t *testing.T, method, url, auth string, out interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	req.Close = true
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdminAuth(t *testing.T) {
+	_, _, srv := adminTestServer(t, &TestHandler{})
+	url := srv.URL + "/debug/limnet/loops"
+	tests := []struct {
+		url  string
+		auth string
+		code int
+	}{
+		{url: url, code: http.StatusUnauthorized},
+		{url: url + "?token=secret", code: http.StatusUnauthorized}, // 不支持url里的token
+		{url: url, auth: "secret", code: http.StatusUnauthorized},
+		{url: url, auth: "Bearer wrong", code: http.StatusUnauthorized},
+		{url: url, auth: "Bearer secret", code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := adminDo(t, http.MethodGet, tt.url, tt.auth, nil); code != tt.code {
+			t.Errorf("%s %q: expect %d but got %d", tt.url, tt.auth, tt.code, code)
+		}
+	}
+}
+
+func TestAdminConns(t *testing.T) {
+	h := newHalfCloseTestHandler()
+	l, addr, srv := adminTestServer(t, h)
+	conn := dial(t, addr)
+	if _, err := conn.Write([]byte("hi\n")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	r := bufio.NewReader(conn)
+	expectLines(t, r, "hi")
+
+	var infos []ConnInfo
+	if code := adminDo(t, http.MethodGet, srv.URL+"/debug/limnet/conns", "Bearer secret", &infos); code != http.StatusOK {
+		t.Fatalf("expect 200 but got %d", code)
+	}
+	if len(infos) != 1 || infos[0].Transport != TransportTCP || infos[0].Addr != conn.LocalAddr().String() {
+		t.Fatalf("unexpected conns %+v", infos)
+	}
+	id := infos[0].ID
+	if l.GetConn(id) == nil {
+		t.Fatalf("expect conn %d", id)
+	}
+
+	closeURL := fmt.Sprintf("%s/debug/limnet/conns/close?id=%d", srv.URL, id)
+	if code := adminDo(t, http.MethodGet, closeURL, "Bearer secret", nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expect 405 but got %d", code)
+	}
+	if code := adminDo(t, http.MethodPost, srv.URL+"/debug/limnet/conns/close?id=abc", "Bearer secret", nil); code != http.StatusBadRequest {
+		t.Fatalf("expect 400 but got %d", code)
+	}
+	if code := adminDo(t, http.MethodPost, srv.URL+"/debug/limnet/conns/close?id=999999", "Bearer secret", nil); code != http.StatusNotFound {
+		t.Fatalf("expect 404 but got %d", code)
+	}
+	// 强制关闭连接
+	if code := adminDo(t, http.MethodPost, closeURL, "Bearer secret", nil); code != http.StatusOK {
+		t.Fatalf("expect 200 but got %d", code)
+	}
+	expectReason(t, h.closed, CloseLocal)
+	expectEOF(t, r)
+	infos = nil
+	if code := adminDo(t, http.MethodGet, srv.URL+"/debug/limnet/conns", "Bearer secret", &infos); code != http.StatusOK || len(infos) != 0 {
+		t.Fatalf("expect no conns but got %d %+v", code, infos)
+	}
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	_, _, srv := adminTestServer(t, &TestHandler{})
+	old := limlog.GetLevel()
+	defer limlog.SetLevel(old)
+
+	url := srv.URL + "/debug/limnet/loglevel"
+	var got map[string]string
+	if code := adminDo(t, http.MethodGet, url, "Bearer secret", &got); code != http.StatusOK || got["level"] != old.String() {
+		t.Fatalf("expect %s but got %d %v", old, code, got)
+	}
+	if code := adminDo(t, http.MethodPost, url+"?level=bad", "Bearer secret", nil); code != http.StatusBadRequest {
+		t.Fatalf("expect 400 but got %d", code)
+	}
+	if code := adminDo(t, http.MethodPost, url+"?level=error", "Bearer secret", &got); code != http.StatusOK || got["level"] != "error" {
+		t.Fatalf("expect error but got %d %v", code, got)
+	}
+	if limlog.GetLevel() != zapcore.ErrorLevel {
+		t.Fatalf("expect log level changed but got %s", limlog.GetLevel())
+	}
+	// 没有token不能修改
+	if code := adminDo(t, http.MethodPost, url+"?level=debug", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("expect 401 but got %d", code)
+	}
+	if limlog.GetLevel() != zapcore.ErrorLevel {
+		t.Fatal("expect log level unchanged")
+	}
+}
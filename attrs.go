@@ -0,0 +1,176 @@
+package limnet
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tangtaoit/limnet/pkg/limutil/sync/atomic"
+)
+
+// AttrKey 连接属性的key，T为属性值的类型，用 NewAttrKey 创建（通常是包级变量），不同的key互不冲突
+type AttrKey[T any] struct {
+	name string
+}
+
+// NewAttrKey 创建连接属性的key，name只用于调试输出
+func NewAttrKey[T any](name string) *AttrKey[T] {
+	return &AttrKey[T]{name: name}
+}
+
+// String key的名字
+func (k *AttrKey[T]) String() string { return k.name }
+
+// GetAttr 获取连接的属性，没有设置过返回T的零值和false
+func GetAttr[T any](c Conn, key *AttrKey[T]) (T, bool) {
+	v, ok := c.Attributes().get(key)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	t, _ := v.(T) // T是接口类型时设置的值可能为nil，断言会失败
+	return t, true
+}
+
+// SetAttr 设置连接的属性
+func SetAttr[T any](c Conn, key *AttrKey[T], value T) {
+	c.Attributes().set(key, value)
+}
+
+// DeleteAttr 删除连接的属性
+func DeleteAttr[T any](c Conn, key *AttrKey[T]) {
+	c.Attributes().delete(key)
+}
+
+// Attributes 连接的属性表，可以在多个goroutine里读写，连接释放时清空，通过 GetAttr/SetAttr 读写
+type Attributes struct {
+	mu sync.RWMutex
+	m  map[interface{}]interface{}
+}
+
+func (a *Attributes) get(key interface{}) (interface{}, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	v, ok := a.m[key]
+	return v, ok
+}
+
+func (a *Attributes) set(key interface{}, value interface{}) {
+	a.mu.Lock()
+	if a.m == nil {
+		a.m = make(map[interface{}]interface{})
+	}
+	a.m[key] = value
+	a.mu.Unlock()
+}
+
+func (a *Attributes) delete(key interface{}) {
+	a.mu.Lock()
+	delete(a.m, key)
+	a.mu.Unlock()
+}
+
+// Len 属性的数量
+func (a *Attributes) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.m)
+}
+
+func (a *Attributes) clear() {
+	a.mu.Lock()
+	a.m = nil
+	a.mu.Unlock()
+}
+
+// connValues 连接上的用户数据（上下文、状态、版本、属性、处理者），TCPConn和WSConn共用，可以在多个goroutine里读写
+type connValues struct {
+	mu       sync.Mutex
+	ctx      interface{}        // 用户自定义的内容
+	handler  ConnHandler        // ConnHandlerFactory创建的处理者，在连接开始读取之前设置
+	cctx     context.Context    // 连接关闭时取消的context，第一次获取时创建
+	cancel   context.CancelFunc // 取消cctx
+	canceled bool               // 连接已关闭
+	status   atomic.Int64       // 用户自定义的连接状态
+	version  atomic.Int32       // 连接使用协议的版本
+	attrs    Attributes
+}
+
+// Context 获取用户上下文内容
+func (v *connValues) Context() interface{} {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.ctx
+}
+
+// SetContext 设置用户上下文内容
+func (v *connValues) SetContext(ctx interface{}) {
+	v.mu.Lock()
+	v.ctx = ctx
+	v.mu.Unlock()
+}
+
+// Status 自定义连接状态
+func (v *connValues) Status() int { return int(v.status.Get()) }
+
+// SetStatus 设置状态
+func (v *connValues) SetStatus(status int) { _ = v.status.Swap(status) }
+
+// Version 协议版本
+func (v *connValues) Version() uint8 { return uint8(v.version.Get()) }
+
+// SetVersion 设置连接的协议版本
+func (v *connValues) SetVersion(version uint8) { _ = v.version.Swap(int(version)) }
+
+// Attributes 连接的属性表
+func (v *connValues) Attributes() *Attributes { return &v.attrs }
+
+// Ctx 连接关闭时取消的context，在OnPacket里发起的下游调用可以用它在连接断开时中止
+func (v *connValues) Ctx() context.Context {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.cctx == nil {
+		v.cctx, v.cancel = context.WithCancel(context.Background())
+		if v.canceled {
+			v.cancel()
+		}
+	}
+	return v.cctx
+}
+
+// cancelCtx 连接关闭，取消Ctx
+func (v *connValues) cancelCtx() {
+	v.mu.Lock()
+	v.canceled = true
+	if v.cancel != nil {
+		v.cancel()
+	}
+	v.mu.Unlock()
+}
+
+// resetValues 释放连接时清空用户上下文、属性和处理者
+func (v *connValues) resetValues() {
+	v.mu.Lock()
+	v.ctx = nil
+	v.handler = nil
+	v.mu.Unlock()
+	v.attrs.clear()
+}
+
+// handlerConn 可以绑定 ConnHandler 的连接
+type handlerConn interface {
+	Conn
+	connHandler() ConnHandler
+	setConnHandler(h ConnHandler)
+}
+
+func (v *connValues) connHandler() ConnHandler {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.handler
+}
+
+func (v *connValues) setConnHandler(h ConnHandler) {
+	v.mu.Lock()
+	v.handler = h
+	v.mu.Unlock()
+}
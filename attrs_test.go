@@ -0,0 +1,86 @@
+package limnet
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAttr(t *testing.T) {
+	userKey := NewAttrKey[string]("user")
+	otherKey := NewAttrKey[string]("user")
+	seqKey := NewAttrKey[int]("seq")
+
+	c := &WSConn{}
+	if _, ok := GetAttr(c, userKey); ok {
+		t.Fatal("expect no attr")
+	}
+	SetAttr(c, userKey, "tom")
+	if v, ok := GetAttr(c, userKey); !ok || v != "tom" {
+		t.Fatalf("expect tom but got %q, %v", v, ok)
+	}
+	if _, ok := GetAttr(c, otherKey); ok {
+		t.Fatal("keys with the same name must not collide")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				SetAttr(c, seqKey, i)
+				_, _ = GetAttr(c, seqKey)
+				c.SetStatus(j)
+				_ = c.Status()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	DeleteAttr(c, seqKey)
+	if c.Attributes().Len() != 1 {
+		t.Fatalf("expect 1 attr but got %d", c.Attributes().Len())
+	}
+	c.resetValues()
+	if _, ok := GetAttr(c, userKey); ok {
+		t.Fatal("expect attrs cleared")
+	}
+}
+
+func TestAttr_NilInterface(t *testing.T) {
+	errKey := NewAttrKey[error]("err")
+	readerKey := NewAttrKey[io.Reader]("reader")
+
+	c := &WSConn{}
+	SetAttr(c, errKey, nil)
+	if v, ok := GetAttr(c, errKey); !ok || v != nil {
+		t.Fatalf("expect nil error set but got %v, %v", v, ok)
+	}
+	SetAttr(c, readerKey, nil)
+	if v, ok := GetAttr(c, readerKey); !ok || v != nil {
+		t.Fatalf("expect nil reader set but got %v, %v", v, ok)
+	}
+	SetAttr[io.Reader](c, readerKey, strings.NewReader("x"))
+	if v, ok := GetAttr(c, readerKey); !ok || v == nil {
+		t.Fatalf("expect reader but got %v, %v", v, ok)
+	}
+}
+
+func TestConnCtx(t *testing.T) {
+	c := &WSConn{}
+	ctx := c.Ctx()
+	if ctx.Err() != nil {
+		t.Fatal("expect ctx not canceled")
+	}
+	c.cancelCtx()
+	<-ctx.Done()
+
+	// 关闭后才获取的ctx也是已取消的
+	c = &WSConn{}
+	c.cancelCtx()
+	if c.Ctx().Err() == nil {
+		t.Fatal("expect ctx canceled")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tangtaoit/limnet"
+)
+
+// broadcastServer 记录所有连接，用于广播
+type broadcastServer struct {
+	limnet.DefaultEventHandler
+	mu    sync.Mutex
+	conns []limnet.Conn
+}
+
+func (h *broadcastServer) OnConnect(c limnet.Conn) {
+	h.mu.Lock()
+	h.conns = append(h.conns, c)
+	h.mu.Unlock()
+}
+
+// countingWriter 统计客户端收到的字节数
+type countingWriter struct{ n *int64 }
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.n, int64(len(p)))
+	return len(p), nil
+}
+
+// BenchmarkBroadcast 从其他goroutine向所有连接写同一条消息，allocs/op包含eventloop里的分配
+func BenchmarkBroadcast(b *testing.B) {
+	const conns, port = 200, 9102
+	h := &broadcastServer{}
+	lm, err := limnet.NewServer(h, limnet.WithAddr(fmt.Sprintf("tcp://127.0.0.1:%d", port)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err = lm.Start(); err != nil {
+		b.Fatal(err)
+	}
+	defer lm.Stop()
+
+	var received int64
+	for i := 0; i < conns; i++ {
+		c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer c.Close()
+		go func() { _, _ = io.Copy(countingWriter{&received}, c) }()
+	}
+	for {
+		h.mu.Lock()
+		n := len(h.conns)
+		h.mu.Unlock()
+		if n == conns {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	msg := []byte("broadcast message 0123456789abcdef")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, c := range h.conns {
+			_ = c.Write(msg)
+		}
+	}
+	want := int64(b.N) * conns * int64(len(msg))
+	for atomic.LoadInt64(&received) < want {
+		time.Sleep(time.Millisecond)
+	}
+	b.StopTimer()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tangtaoit/limnet"
+)
+
+// headerBodyServer 每个请求分开写出4字节的包头和包体
+type headerBodyServer struct {
+	limnet.DefaultEventHandler
+	vectored bool
+}
+
+func (h *headerBodyServer) OnPacket(c limnet.Conn, data []byte) []byte {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	body := append([]byte(nil), data...)
+	if h.vectored {
+		_ = c.Writev(header, body)
+	} else {
+		_ = c.Write(header)
+		_ = c.Write(body)
+	}
+	return nil
+}
+
+// writeSyscalls 当前进程的写系统调用次数（/proc/self/io的syscw，非linux返回-1）
+func writeSyscalls() int64 {
+	f, err := os.Open("/proc/self/io")
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "syscw:") {
+			n, _ := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "syscw:")), 10, 64)
+			return n
+		}
+	}
+	return -1
+}
+
+func benchmarkHeaderBody(b *testing.B, port int, vectored bool) {
+	lm, err := limnet.NewServer(&headerBodyServer{vectored: vectored}, limnet.WithAddr(fmt.Sprintf("tcp://127.0.0.1:%d", port)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err = lm.Start(); err != nil {
+		b.Fatal(err)
+	}
+	defer lm.Stop()
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	req := make([]byte, 64)
+	resp := make([]byte, 4+len(req))
+	b.SetBytes(int64(len(resp)))
+	b.ResetTimer()
+	start := writeSyscalls()
+	for i := 0; i < b.N; i++ {
+		if _, err = conn.Write(req); err != nil {
+			b.Fatal(err)
+		}
+		if _, err = io.ReadFull(conn, resp); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	if start >= 0 {
+		// 包含客户端每次请求的1次write
+		b.ReportMetric(float64(writeSyscalls()-start)/float64(b.N), "syscw/op")
+	}
+}
+
+// BenchmarkHeaderBodyWrite 包头和包体分两次Write，每个响应2次write系统调用（第二个小包还会被Nagle算法延迟到对端ACK）
+func BenchmarkHeaderBodyWrite(b *testing.B) {
+	benchmarkHeaderBody(b, 9100, false)
+}
+
+// BenchmarkHeaderBodyWritev 包头和包体用Writev写出，每个响应1次writev系统调用
+func BenchmarkHeaderBodyWritev(b *testing.B) {
+	benchmarkHeaderBody(b, 9101, true)
+}
@@ -0,0 +1,114 @@
+package limnet
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	syncatomic "sync/atomic"
+
+	"github.com/tangtaoit/limnet/pkg/limutil/sync/atomic"
+)
+
+// maxPooledBufferSize 超过此大小的Buffer释放后不放回池里
+const maxPooledBufferSize = 1 << 20
+
+// bufferPoison 调试模式下释放后的Buffer用此字节填充，继续使用的数据一眼可见
+const bufferPoison = 0xdd
+
+// ErrBufferReleased Buffer引用计数归零后继续使用（调试模式下检测）
+var ErrBufferReleased = errors.New("Buffer已释放")
+
+var (
+	bufferPool  = sync.Pool{New: func() interface{} { return &Buffer{} }}
+	bufferDebug atomic.Bool // 调试模式，见 WithBufferDebug
+)
+
+// Buffer 从池里分配的引用计数buffer，引用计数归零后放回池里复用
+// 创建时引用计数为1，需要在其他goroutine里使用时先Retain，每个Retain对应一次Release
+type Buffer struct {
+	b          []byte
+	refs       int32
+	releasedAt []byte // 调试模式下最后一次Release归零时的堆栈
+}
+
+// NewBuffer 从池里分配长度为size的Buffer，引用计数为1
+func NewBuffer(size int) *Buffer {
+	buf := bufferPool.Get().(*Buffer)
+	if cap(buf.b) < size {
+		buf.b = make([]byte, size)
+	}
+	buf.b = buf.b[:size]
+	buf.refs = 1
+	buf.releasedAt = nil
+	return buf
+}
+
+// CopyBuffer 分配Buffer并拷贝data
+func CopyBuffer(data []byte) *Buffer {
+	buf := NewBuffer(len(data))
+	copy(buf.b, data)
+	return buf
+}
+
+// Bytes Buffer的数据，只在持有引用期间有效
+func (b *Buffer) Bytes() []byte {
+	b.check()
+	return b.b
+}
+
+// Len 数据长度
+func (b *Buffer) Len() int {
+	return len(b.b)
+}
+
+// RefCount 当前引用计数
+func (b *Buffer) RefCount() int {
+	return int(syncatomic.LoadInt32(&b.refs))
+}
+
+// Retain 增加一个引用
+func (b *Buffer) Retain() *Buffer {
+	if syncatomic.AddInt32(&b.refs, 1) <= 1 {
+		panic(b.releasedError())
+	}
+	return b
+}
+
+// Release 释放一个引用，归零后放回池里（调试模式下不复用，数据填充为0xdd）
+func (b *Buffer) Release() {
+	if b == nil {
+		return
+	}
+	refs := syncatomic.AddInt32(&b.refs, -1)
+	if refs > 0 {
+		return
+	}
+	if refs < 0 {
+		panic(b.releasedError())
+	}
+	if bufferDebug.Get() {
+		for i := range b.b {
+			b.b[i] = bufferPoison
+		}
+		b.releasedAt = debug.Stack()
+		return
+	}
+	if cap(b.b) <= maxPooledBufferSize {
+		bufferPool.Put(b)
+	}
+}
+
+// check 调试模式下检测释放后使用
+func (b *Buffer) check() {
+	if bufferDebug.Get() && syncatomic.LoadInt32(&b.refs) <= 0 {
+		panic(b.releasedError())
+	}
+}
+
+func (b *Buffer) releasedError() error {
+	if len(b.releasedAt) == 0 {
+		return ErrBufferReleased
+	}
+	return fmt.Errorf("%w，释放位置：\n%s", ErrBufferReleased, b.releasedAt)
+}
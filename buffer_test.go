@@ -0,0 +1,83 @@
+package limnet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tangtaoit/limnet/pkg/ringbuffer"
+)
+
+func TestBuffer_RetainRelease(t *testing.T) {
+	buf := CopyBuffer([]byte("hello"))
+	if buf.RefCount() != 1 || string(buf.Bytes()) != "hello" {
+		t.Fatalf("expect refs 1 and hello but got %d and %s", buf.RefCount(), buf.Bytes())
+	}
+	buf.Retain()
+	buf.Release()
+	if buf.RefCount() != 1 {
+		t.Fatalf("expect refs 1 but got %d", buf.RefCount())
+	}
+	buf.Release()
+
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, ErrBufferReleased) {
+			t.Fatalf("expect ErrBufferReleased but got %v", err)
+		}
+	}()
+	buf.Release()
+}
+
+func TestBuffer_Debug(t *testing.T) {
+	bufferDebug.Set(true)
+	defer bufferDebug.Set(false)
+
+	buf := CopyBuffer([]byte("hello"))
+	data := buf.Bytes()
+	buf.Release()
+	for _, b := range data {
+		if b != bufferPoison {
+			t.Fatalf("expect poisoned data but got %q", data)
+		}
+	}
+	if NewBuffer(5) == buf {
+		t.Fatalf("expect released buffer not reused in debug mode")
+	}
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, ErrBufferReleased) {
+			t.Fatalf("expect ErrBufferReleased but got %v", err)
+		}
+	}()
+	_ = buf.Bytes()
+}
+
+// wrappedInbound 数据跨过ringbuffer末尾的inboundBuffer
+func wrappedInbound() *ringbuffer.RingBuffer {
+	rb := ringbuffer.New(8)
+	_, _ = rb.Write([]byte("xxxxab"))
+	rb.Shift(4)
+	_, _ = rb.Write([]byte("cdef")) // ab在末尾，cdef回绕到开头
+	return rb
+}
+
+func TestConn_ReadPutsByteBuffer(t *testing.T) {
+	c := &TCPConn{inboundBuffer: wrappedInbound()}
+	c.connected.Set(true)
+	if data := c.Read(); string(data) != "abcdef" {
+		t.Fatalf("expect abcdef but got %q", data)
+	}
+	first := c.byteBuffer
+	if _, data := c.ReadN(4); string(data) != "abcd" {
+		t.Fatalf("expect abcd but got %q", data)
+	}
+	// 上一次合并的buffer已放回池里（放回时被重置，可能又被这次取出）
+	if first != c.byteBuffer && first.Len() != 0 {
+		t.Fatal("expect previous byteBuffer put back to pool")
+	}
+	second := c.byteBuffer
+	if data := c.Read(); string(data) != "abcdef" {
+		t.Fatalf("expect abcdef but got %q", data)
+	}
+	if second != c.byteBuffer && second.Len() != 0 {
+		t.Fatal("expect previous byteBuffer put back to pool")
+	}
+}
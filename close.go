@@ -0,0 +1,70 @@
+package limnet
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrWriteClosed 已调用CloseWrite关闭写方向后继续写入
+var ErrWriteClosed = errors.New("连接的写方向已关闭")
+
+// CloseReason 连接关闭的原因，在OnClose里通过 Conn.CloseReason 获取
+type CloseReason string
+
+const (
+	// ClosePeerEOF 对端关闭了连接（读到EOF或者websocket关闭帧）
+	ClosePeerEOF CloseReason = "peer_eof"
+	// CloseReadError 读数据出错，错误见 Conn.CloseErr
+	CloseReadError CloseReason = "read_error"
+	// CloseWriteError 写数据出错，错误见 Conn.CloseErr
+	CloseWriteError CloseReason = "write_error"
+	// ClosePollerError poller返回了连接的错误事件，错误（SO_ERROR）见 Conn.CloseErr
+	ClosePollerError CloseReason = "poller_error"
+	// CloseFlushTimeout CloseAfterFlush 超时后还有数据没写完
+	CloseFlushTimeout CloseReason = "flush_timeout"
+	// CloseIdleTimeout 连接空闲超时
+	CloseIdleTimeout CloseReason = "idle_timeout"
+	// CloseLocal 调用了 Conn.Close
+	CloseLocal CloseReason = "local"
+	// CloseServerStop 服务停止
+	CloseServerStop CloseReason = "server_stop"
+	// CloseProtocolViolation 违反协议（解包错误、PROXY协议头错误、输入buffer超限等），错误见 Conn.CloseErr
+	CloseProtocolViolation CloseReason = "protocol_violation"
+	// ClosePacketRate 超过包速率限制
+	ClosePacketRate CloseReason = "packet_rate"
+	// CloseMemoryBudget 所有连接缓冲的内存超过 Options.MaxBufferMemory 时还在缓冲数据
+	CloseMemoryBudget CloseReason = "memory_budget"
+	// CloseEvictedBacklog 缓冲的内存超过驱逐上限，输出积压最多被驱逐
+	CloseEvictedBacklog CloseReason = "evicted_backlog"
+	// CloseEvictedStalled 缓冲的内存超过驱逐上限，写出停滞最久被驱逐
+	CloseEvictedStalled CloseReason = "evicted_stalled"
+)
+
+// HalfCloseHandler 可选接口，EventHandler实现后对端半关闭（只关闭写方向）时连接保持打开，
+// 还可以继续写出回复，写完后调用 Conn.CloseWrite 或 Conn.Close 结束连接。
+// 未实现时对端半关闭后写完已有的回复就关闭连接（原因为 ClosePeerEOF）
+type HalfCloseHandler interface {
+	OnPeerHalfClose(c Conn)
+}
+
+// closeCause 记录连接关闭的原因，只保留第一次设置的
+type closeCause struct {
+	mu     sync.Mutex
+	reason CloseReason
+	err    error
+}
+
+func (c *closeCause) set(reason CloseReason, err error) {
+	c.mu.Lock()
+	if c.reason == "" {
+		c.reason = reason
+		c.err = err
+	}
+	c.mu.Unlock()
+}
+
+func (c *closeCause) get() (CloseReason, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reason, c.err
+}
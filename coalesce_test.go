@@ -0,0 +1,98 @@
+package limnet
+
+import (
+	"bufio"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeCountMetrics 记录写出的次数
+type writeCountMetrics struct {
+	*DefaultMetrics
+	writes int32
+}
+
+func (m *writeCountMetrics) BytesOut(t Transport, n int) {
+	atomic.AddInt32(&m.writes, 1)
+	m.DefaultMetrics.BytesOut(t, n)
+}
+
+func (m *writeCountMetrics) count() int32 { return atomic.LoadInt32(&m.writes) }
+
+type coalesceHandler struct {
+	DefaultEventHandler
+	metrics *writeCountMetrics
+	probes  chan int32
+}
+
+func (h *coalesceHandler) OnPacket(c Conn, data []byte) []byte {
+	switch string(data) {
+	case "block": // 阻塞eventloop，让其他连接的数据在下一轮一起处理
+		time.Sleep(300 * time.Millisecond)
+		return nil
+	case "probe": // 记录此时已经写出的次数
+		h.probes <- h.metrics.count()
+		return nil
+	case "flush":
+		_ = c.Flush()
+	}
+	return append(data, '\n')
+}
+
+func withOneConnLoop(opts *Options) error {
+	opts.ConnEventLoopNum = 1
+	return nil
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		m := &writeCountMetrics{DefaultMetrics: NewDefaultMetrics()}
+		h := &coalesceHandler{metrics: m}
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(lineUnPacket), WithWriteCoalescing(true), WithMetrics(m))
+		conn := dial(t, addr)
+		if _, err := conn.Write([]byte("a\nb\nc\nd\n")); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		expectLines(t, bufio.NewReader(conn), "a", "b", "c", "d")
+		// 一轮事件循环里的回复合并成一次写出
+		if n := m.count(); n != 1 {
+			t.Fatalf("expect 1 write but got %d", n)
+		}
+	})
+}
+
+// io_uring模式下send在一轮结束时才统一提交，本轮里Flush不会更早写出，只测epoll/kqueue
+func TestWriteCoalescing_Flush(t *testing.T) {
+	for _, packet := range []string{"x", "flush"} {
+		m := &writeCountMetrics{DefaultMetrics: NewDefaultMetrics()}
+		h := &coalesceHandler{metrics: m, probes: make(chan int32, 1)}
+		_, addr := startServer(t, h, WithPoller(PollerEpoll), WithUnPacket(lineUnPacket), WithWriteCoalescing(true), WithMetrics(m), withOneConnLoop)
+		blocker := dial(t, addr)
+		writer := dial(t, addr)
+		prober := dial(t, addr)
+		if _, err := blocker.Write([]byte("block\n")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		// eventloop阻塞期间writer先就绪，prober后就绪，下一轮按顺序处理
+		if _, err := writer.Write([]byte(packet + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if _, err := prober.Write([]byte("probe\n")); err != nil {
+			t.Fatal(err)
+		}
+		// 没有Flush时writer的回复要等这一轮结束才写出，Flush后在处理下一个连接前就写出了
+		want := int32(0)
+		if packet == "flush" {
+			want = 1
+		}
+		if n := recv(t, h.probes); n != want {
+			t.Fatalf("%s: expect %d writes before probe but got %d", packet, want, n)
+		}
+		_ = writer.SetReadDeadline(time.Now().Add(3 * time.Second))
+		expectLines(t, bufio.NewReader(writer), packet)
+	}
+}
@@ -1,15 +1,22 @@
 package limnet
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/tangtaoit/limnet/pkg/bytebuffer"
 	"github.com/tangtaoit/limnet/pkg/eventloop"
 	"github.com/tangtaoit/limnet/pkg/limlog"
 	"github.com/tangtaoit/limnet/pkg/limpoller"
+	"github.com/tangtaoit/limnet/pkg/limutil"
 	"github.com/tangtaoit/limnet/pkg/limutil/sync/atomic"
+	"github.com/tangtaoit/limnet/pkg/proxyproto"
 	"github.com/tangtaoit/limnet/pkg/ringbuffer"
 	"go.uber.org/zap"
 	"golang.org/x/sys/unix"
@@ -30,16 +37,42 @@ type Conn interface {
 	ReadN(n int) (size int, buf []byte)
 	// ShiftN 移动指定长度的下标
 	ShiftN(n int) (size int)
+	// Peek 查看前n个字节（n<=0表示全部）但不移动下标，数据不连续时分成head和tail两段，只在调用期间有效
+	Peek(n int) (head, tail []byte)
+	// Discard 丢弃前n个字节，返回实际丢弃的字节数
+	Discard(n int) int
 	// 写数据
 	Write(buf []byte) (err error)
+	// WriteBuffer 写出引用计数的Buffer（不拷贝），接管调用方的一个引用，写出后释放
+	WriteBuffer(buf *Buffer) (err error)
+	// Writev 写多个buffer（例如分开的包头和包体），作为一个包一次写出
+	Writev(bufs ...[]byte) (err error)
+	// WriteWithCallback 写数据，数据全部交给内核或者写失败（包括连接关闭）时调用一次cb，返回错误时不会调用cb
+	WriteWithCallback(buf []byte, cb func(err error)) (err error)
+	// Flush 合并写模式下立即写出已合并的数据
+	Flush() (err error)
+	// SendFile 发送文件从offset开始的count个字节（count<=0表示到文件末尾），和其他写入按顺序发送
+	SendFile(f *os.File, offset, count int64) (err error)
 	// 关闭连接
 	Close() error
+	// CloseAfterFlush 不再读取，写完已写入的数据后关闭连接，timeout后还没写完则直接关闭（timeout<=0不限时）
+	CloseAfterFlush(timeout time.Duration) error
+	// CloseWrite 写完已写入的数据后关闭写方向（shutdown SHUT_WR），对端读到EOF，连接还可以继续读
+	CloseWrite() error
+	// CloseReason 连接关闭的原因（OnClose里可用），未记录原因的关闭返回空字符串
+	CloseReason() CloseReason
+	// CloseErr 导致连接关闭的错误（读写错误、协议错误等），正常关闭为nil
+	CloseErr() error
 	// 获取连接地址
 	GetAddr() string
 	// Context 获取用户上下文内容
 	Context() interface{}
 	// SetContext 设置用户上下文内容
 	SetContext(ctx interface{})
+	// Ctx 连接关闭时取消的context
+	Ctx() context.Context
+	// Attributes 连接的属性表（并发安全，连接释放时清空），通过 GetAttr/SetAttr 读写
+	Attributes() *Attributes
 	// Status 自定义连接状态
 	Status() int
 	// SetStatus 设置状态
@@ -48,6 +81,20 @@ type Conn interface {
 	Version() uint8
 	// SetVersion 设置连接的协议版本
 	SetVersion(version uint8)
+	// ProxyHeader 连接的PROXY协议头，未使用PROXY协议返回nil
+	ProxyHeader() *proxyproto.Header
+	// LocalAddr 本地地址（使用PROXY协议时为代理记录的目标地址）
+	LocalAddr() net.Addr
+	// RemoteAddr 对端地址（使用PROXY协议或信任代理转发时为真实客户端地址）
+	RemoteAddr() net.Addr
+	// Listener 连接来自的监听地址
+	Listener() string
+	// Transport 连接的传输类型
+	Transport() Transport
+	// ConnectTime 连接建立的时间
+	ConnectTime() time.Time
+	// TLSState TLS连接的状态（协商的协议、对端证书等），非TLS连接返回nil
+	TLSState() *tls.ConnectionState
 }
 
 // TCPConn tcp连接
@@ -55,36 +102,95 @@ type TCPConn struct {
 	id        int64 // 客户端唯一ID
 	fd        int   // 连接fd
 	loop      *eventloop.EventLoop
+	loopIndex int // 所属eventloop的下标
 	connected atomic.Bool
 	lnet      *LIMNet
-	ctx       interface{} // 用户自定义的内容
-	status    int         // 用户自定义的连接状态
-	version   uint8       // 连接使用协议的版本
-	buffer    []byte      // inbound的临时buffer
+	buffer    []byte // inbound的临时buffer
 	limlog.Log
-	inboundBuffer  *ringbuffer.RingBuffer // 来自客户端的数据
-	outboundBuffer *ringbuffer.RingBuffer // 将要写到客户端的数据
-	byteBuffer     *bytebuffer.ByteBuffer // 临时读到的buffer
-	activeTime     atomic.Int64           // 连接最后一次活动时间，单位秒
-	addr           string
+	connValues
+	inboundBuffer   *ringbuffer.RingBuffer // 来自客户端的数据
+	outboundBuffer  *ringbuffer.RingBuffer // 将要写到客户端的数据
+	byteBuffer      *bytebuffer.ByteBuffer // 临时读到的buffer
+	activeTime      atomic.Int64           // 连接最后一次活动时间，单位秒
+	addr            string                 // 客户端地址（使用PROXY协议时为真实客户端地址）
+	limitAddr       string                 // 占用单个IP连接数名额的地址，释放时使用，还没占用为空
+	packetLimiter   *limutil.TokenBucket   // 包速率限制，未开启为nil
+	throttled       bool                   // 是否因超过包速率而暂停读取
+	proxyPending    bool                   // 是否等待PROXY协议头
+	proxyHeader     *proxyproto.Header     // PROXY协议头
+	localAddr       net.Addr
+	remoteAddr      net.Addr
+	listener        *Listener      // 连接来自的监听器
+	connectTime     time.Time      // 连接建立的时间
+	recvActive      bool           // io_uring模式下multishot recv是否在进行中
+	sending         [][]byte       // io_uring模式下正在发送的数据（发送完成前需要持有引用）
+	sendPending     int            // io_uring模式下未完成的send数量
+	sendingFile     bool           // io_uring模式下正在发送的是文件块
+	fileChunk       []byte         // io_uring模式下读取文件块的buffer，文件段都发送完后释放
+	iovecs          []unix.Iovec   // writev复用的iovec
+	files           []*fileSegment // 排队等待sendfile的文件段
+	fileGap         int            // 最后一个文件段之前还在outboundBuffer里的字节数
+	spliceTo        *splicer       // 读到的数据splice转发到其他连接
+	spliceFrom      *splicer       // 其他连接splice转发过来的数据
+	splicePaused    bool           // 管道里的数据还没转发完，暂停读取
+	readClosed      bool           // 对端已关闭写方向（读到EOF），不再读取
+	writeClosed     bool           // 已shutdown写方向
+	shutdownPending bool           // CloseWrite等待待写出的数据写完
+	closePending    bool           // CloseAfterFlush等待待写出的数据写完后关闭
+	queued          int64          // 进入输出流的字节数（已写出的加上输出buffer、文件段里的）
+	written         int64          // 已交给内核的字节数
+	writeCallbacks  []writeCallback
+	dirty           bool          // 合并写模式下是否已登记到eventloop等待flush
+	stageMu         sync.Mutex    // 保护staged
+	staged          []stagedWrite // Write等写入的数据，等待eventloop写出
+	stagedPackets   int           // staged里的包数量
+	stageQueued     bool          // 是否已通过TriggerFlush通知eventloop
+	draining        []stagedWrite // 复用的staged
+	drainBufs       [][]byte      // 复用的writev参数
+	writeStalledAt  time.Time     // 输出buffer有积压时最后一次写出进展的时间
+	cause           closeCause    // 连接关闭的原因
 }
 
-// NewTCPConn 创建连接
+// stagedWrite 暂存的一次写入，buf或者文件段
+type stagedWrite struct {
+	buf []byte
+	seg *fileSegment
+	ref *Buffer         // buf来自WriteBuffer时为对应的Buffer，写出后释放
+	cb  func(err error) // buf来自WriteWithCallback时为对应的回调
+}
+
+// writeCallback 等待输出流写到end时调用的回调
+type writeCallback struct {
+	end int64
+	fn  func(err error)
+}
+
+// NewTCPConn 创建默认tcp监听器的连接
 func NewTCPConn(id int64, connfd int, addr string, loop *eventloop.EventLoop, lnet *LIMNet) *TCPConn {
+	return newTCPConn(id, connfd, addr, loop, lnet.defaultListener(TransportTCP))
+}
+
+// newTCPConn 创建监听器ln的连接
+func newTCPConn(id int64, connfd int, addr string, loop *eventloop.EventLoop, ln *Listener) *TCPConn {
+	lnet := ln.lnet
 	conn := &TCPConn{
-		id:             id,
-		fd:             connfd,
-		loop:           loop,
-		lnet:           lnet,
-		addr:           addr,
-		Log:            limlog.NewLIMLog(fmt.Sprintf("Conn[connfd:%d]", connfd)),
-		inboundBuffer:  ringbuffer.Get(),
-		outboundBuffer: ringbuffer.Get(),
+		id:            id,
+		fd:            connfd,
+		loop:          loop,
+		lnet:          lnet,
+		addr:          addr,
+		Log:           limlog.NewLIMLog(fmt.Sprintf("Conn[connfd:%d]", connfd)),
+		packetLimiter: newPacketLimiter(lnet.opts),
+		listener:      ln,
+		connectTime:   time.Now(),
+	}
+	if sa, err := unix.Getsockname(connfd); err == nil {
+		conn.localAddr = sockAddrToTCPAddr(sa)
 	}
 	conn.connected.Set(true)
-	if lnet.opts.ConnIdleTime > 0 {
-		_ = conn.activeTime.Swap(int(time.Now().Unix()))
-		lnet.timingWheel.AfterFunc(lnet.opts.ConnIdleTime, conn.closeTimeoutConn())
+	_ = conn.activeTime.Swap(int(time.Now().Unix()))
+	if idleTime := ln.idleTime(); idleTime > 0 {
+		lnet.timingWheel.AfterFunc(idleTime, conn.closeTimeoutConn())
 	}
 	return conn
 }
@@ -97,10 +203,11 @@ func (c *TCPConn) closeTimeoutConn() func() {
 		}
 		now := time.Now()
 		intervals := now.Sub(time.Unix(c.activeTime.Get(), 0))
-		if intervals >= c.lnet.opts.ConnIdleTime {
-			_ = c.Close()
+		idleTime := c.listener.idleTime()
+		if intervals >= idleTime {
+			_ = c.closeWithReason(CloseIdleTimeout)
 		} else {
-			c.lnet.timingWheel.AfterFunc(c.lnet.opts.ConnIdleTime-intervals, c.closeTimeoutConn())
+			c.lnet.timingWheel.AfterFunc(idleTime-intervals, c.closeTimeoutConn())
 		}
 	}
 }
@@ -109,95 +216,535 @@ func (c *TCPConn) closeTimeoutConn() func() {
 
 // Handle 处理事件通知
 func (c *TCPConn) Handle(connfd int, events limpoller.Event) {
-	if c.lnet.opts.ConnIdleTime > 0 {
-		_ = c.activeTime.Swap(int(time.Now().Unix()))
-	}
+	_ = c.activeTime.Swap(int(time.Now().Unix()))
 
 	if events&limpoller.EventErr != 0 {
-		c.handleClose(connfd)
+		c.closeWith(ClosePollerError, socketError(connfd))
 		return
 	}
-	switch c.outboundBuffer.IsEmpty() {
-	case false:
+	switch c.writePending() {
+	case true:
 		if events&limpoller.EventWrite != 0 {
 			c.handleWrite()
 		}
-		return
-	case true:
-		if events&limpoller.EventRead != 0 {
+		// 对端已半关闭时读完剩余的数据，否则可读事件会一直触发
+		if events&limpoller.EventReadHup == 0 || !c.connected.Get() || c.readPaused() {
+			return
+		}
+		fallthrough
+	case false:
+		if events&limpoller.EventRead != 0 && !c.readClosed {
 			c.handleRead()
 		}
 	}
 
 }
 
+// HandleError eventloop恢复的Handle中的panic
+func (c *TCPConn) HandleError(err error) {
+	c.listener.onError(c, err)
+}
+
 func (c *TCPConn) handleRead() error {
-	buf := c.loop.PacketBuf()
-	n, err := unix.Read(c.fd, buf)
+	if c.spliceTo != nil {
+		return c.handleSplice()
+	}
+	rb := c.inbound()
+	size := rb.Cap()
+	n, err := rb.ReadFromFd(c.fd) // 直接读到inboundBuffer，不经过临时buffer
+	c.lnet.bufferResized(rb.Cap() - size)
 	if n == 0 || err != nil {
 		if err == unix.EAGAIN {
+			c.releaseInbound()
 			return nil
 		}
-		return c.handleClose(c.fd)
+		if err != nil {
+			return c.closeWith(CloseReadError, err)
+		}
+		c.releaseInbound()
+		return c.handlePeerEOF()
 	}
-	c.buffer = buf[:n]
+	c.lnet.metrics.BytesIn(TransportTCP, n)
+	return c.handleInbound()
+}
 
+// handleData 处理io_uring读到的数据 data只在调用期间有效
+// inboundBuffer里有未处理的数据时直接追加到inboundBuffer，否则就地解包，剩余不足一个包的数据再写入inboundBuffer
+func (c *TCPConn) handleData(data []byte) error {
+	c.lnet.metrics.BytesIn(TransportTCP, len(data))
+	if c.inboundBuffer.IsEmpty() {
+		c.buffer = data
+	} else if _, err := c.writeInbound(data); err != nil {
+		return err
+	}
+	return c.handleInbound()
+}
+
+// handleInbound 解包处理已读到的数据（c.buffer不为空时inboundBuffer一定为空）
+func (c *TCPConn) handleInbound() error {
+	if !c.proxyPending || c.handleProxyHeader() {
+		c.handlePackets()
+	}
+	if !c.connected.Get() {
+		return nil
+	}
+	if c.listener.inboundExceeded(c.BufferLength()) {
+		c.protocolViolation(ErrInboundBufferExceeded)
+		return nil
+	}
+	var err error
+	if len(c.buffer) > 0 {
+		_, err = c.writeInbound(c.buffer)
+	}
+	c.buffer = nil
+	c.releaseInbound()
+	c.checkBudget()
+	return err
+}
+
+// HandleCompletion io_uring模式下操作完成
+func (c *TCPConn) HandleCompletion(op eventloop.Op, res int32, more bool, buf []byte) {
+	_ = c.activeTime.Swap(int(time.Now().Unix()))
+	switch op {
+	case eventloop.OpRecv:
+		c.handleRecv(res, more, buf)
+	case eventloop.OpSend:
+		c.handleSent(res)
+	}
+}
+
+func (c *TCPConn) handleRecv(res int32, more bool, buf []byte) {
+	if !more {
+		c.recvActive = false
+	}
+	if !c.connected.Get() {
+		return
+	}
+	if res > 0 {
+		if c.closePending { // 等待关闭，丢弃读到的数据
+			return
+		}
+		if err := c.handleData(buf); err != nil {
+			c.listener.onError(c, err)
+		}
+	} else if res == 0 { // 对端关闭了写方向，不再提交recv
+		_ = c.handlePeerEOF()
+		return
+	} else if errno := unix.Errno(-res); errno != unix.ENOBUFS && errno != unix.ECANCELED {
+		_ = c.closeWith(CloseReadError, errno)
+		return
+	}
+	if c.connected.Get() && !c.recvActive && !c.readPaused() {
+		if err := c.submitRecv(); err != nil {
+			c.listener.onError(c, err)
+		}
+	}
+}
+
+func (c *TCPConn) handleSent(res int32) {
+	c.sendPending--
+	if c.connected.Get() {
+		if res > 0 && c.sendingFile {
+			c.fileChunkSent(int(res))
+		} else if res > 0 {
+			c.shiftOutbound(int(res))
+			if len(c.files) > 0 {
+				c.files[0].gap -= int(res)
+				c.fileGap -= int(res)
+			}
+		} else if errno := unix.Errno(-res); res < 0 && errno != unix.ECANCELED {
+			c.listener.onError(c, errno)
+			_ = c.closeWith(CloseWriteError, errno)
+		}
+	}
+	if c.sendPending > 0 {
+		return
+	}
+	c.sending = c.sending[:0]
+	c.sendingFile = false
+	if c.connected.Get() {
+		if err := c.submitSend(); err != nil { // 发送剩余的数据（部分发送或者发送期间写入的数据）
+			c.listener.onError(c, err)
+		}
+		c.notifyWritten()
+		c.writeComplete()
+		c.onDrained()
+	}
+}
+
+// submitRecv 提交multishot recv
+func (c *TCPConn) submitRecv() error {
+	if c.recvActive {
+		return nil
+	}
+	c.recvActive = true
+	return c.loop.SubmitRecv(uint64(c.id), c.fd)
+}
+
+// submitSend 提交outboundBuffer里的数据，同时只有一批send在进行
+// 有排队的文件段时只发送文件段之前的数据，之后按块发送文件
+func (c *TCPConn) submitSend() error {
+	if c.sendPending > 0 {
+		return nil
+	}
+	limit := c.outboundBuffer.Length()
+	if len(c.files) > 0 && c.files[0].gap < limit {
+		limit = c.files[0].gap
+	}
+	if limit == 0 {
+		if len(c.files) == 0 {
+			return nil
+		}
+		return c.submitFileChunk()
+	}
+	head, tail := c.outboundBuffer.LazyRead(limit)
+	c.sending = append(c.sending[:0], head)
+	if len(tail) > 0 {
+		c.sending = append(c.sending, tail)
+	}
+	c.sendPending = len(c.sending)
+	return c.loop.SubmitSend(uint64(c.id), c.fd, c.sending)
+}
+
+// protocolViolation 违反协议，写出告别数据后关闭连接
+func (c *TCPConn) protocolViolation(err error) {
+	if out := c.listener.goodbye(c, err); len(out) > 0 {
+		c.write(out)
+	}
+	_ = c.closeWith(CloseProtocolViolation, err)
+}
+
+// handlePackets 解包并触发OnPacket，超过包速率时停止
+func (c *TCPConn) handlePackets() {
 	// c.read()会触发c.lnet.proto.UnPacket UnPacket会触发当前的 Read
-	for packet, _ := c.read(); packet != nil; packet, _ = c.read() {
-		out := c.lnet.eventHandler.OnPacket(c, packet)
+	for {
+		if c.packetLimiter != nil {
+			if wait := c.packetLimiter.Wait(); wait > 0 {
+				c.limitPacketRate(wait)
+				return
+			}
+		}
+		packet, err := c.read()
+		if err != nil {
+			if c.listener.decodeError(c, err) {
+				c.protocolViolation(err)
+			}
+			return
+		}
+		if packet == nil {
+			return
+		}
+		if c.packetLimiter != nil {
+			c.packetLimiter.Allow()
+		}
+		c.lnet.metrics.PacketIn(TransportTCP)
+		out, buf := c.listener.onPacket(c, packet)
 		if len(out) > 0 {
-			c.write(out)
+			c.write(c.listener.pack(c, out))
+		}
+		buf.Release() // out可能引用buf，写出之后才释放
+		if !c.connected.Get() {
+			return
 		}
 	}
-	_, err = c.inboundBuffer.Write(c.buffer)
-	return err
+}
+
+// limitPacketRate 超过包速率后关闭连接或暂停读取wait时长
+func (c *TCPConn) limitPacketRate(wait time.Duration) {
+	if c.lnet.opts.PacketRateAction == RateActionClose {
+		c.Warn("超过包速率限制，关闭连接！", zap.String("addr", c.addr))
+		_ = c.closeWith(ClosePacketRate, nil)
+		return
+	}
+	if c.throttled {
+		return
+	}
+	c.throttled = true
+	var err error
+	if c.loop.IsRing() {
+		if c.recvActive {
+			err = c.loop.SubmitCancel(uint64(c.id), eventloop.OpRecv)
+		}
+	} else if !c.writePending() {
+		err = c.loop.Poller().DisableReadWrite(c.fd)
+	} else {
+		err = c.loop.Poller().EnableWrite(c.fd)
+	}
+	if err != nil {
+		c.Error("暂停读取失败！", zap.Error(err))
+	}
+	c.lnet.timingWheel.AfterFunc(wait, func() {
+		_ = c.loop.Trigger(func() error {
+			if err := c.resumeRead(); err != nil {
+				c.listener.onError(c, err)
+			}
+			return nil
+		})
+	})
+}
+
+// resumeRead 限流结束，处理已缓存的数据并恢复读取
+func (c *TCPConn) resumeRead() error {
+	if !c.connected.Get() || !c.throttled {
+		return nil
+	}
+	c.throttled = false
+	c.buffer = nil
+	c.handlePackets()
+	c.releaseInbound()
+	if !c.connected.Get() || c.readPaused() {
+		return nil
+	}
+	if c.loop.IsRing() {
+		return c.submitRecv()
+	}
+	if !c.writePending() {
+		return c.loop.Poller().EnableRead(c.fd)
+	}
+	return c.loop.Poller().EnableReadWrite(c.fd)
+}
+
+// writePending 是否还有等待写出的数据（输出buffer、文件段或者splice管道里的数据）
+func (c *TCPConn) writePending() bool {
+	return !c.outboundBuffer.IsEmpty() || len(c.files) > 0 || (c.spliceFrom != nil && c.spliceFrom.pending())
+}
+
+// readPaused 是否暂停了读取（限流中、等待splice转发、对端已关闭写方向或者等待关闭）
+func (c *TCPConn) readPaused() bool {
+	return c.throttled || c.splicePaused || c.readClosed || c.closePending
+}
+
+// writeShut 是否已关闭写方向（CloseWrite、CloseAfterFlush之后不能再写）
+func (c *TCPConn) writeShut() bool {
+	return c.writeClosed || c.shutdownPending || c.closePending
+}
+
+// enableWrite 注册可写事件（暂停读取时只注册可写事件）
+func (c *TCPConn) enableWrite() error {
+	if c.loop.IsRing() {
+		return c.submitSend()
+	}
+	if c.readPaused() {
+		return c.loop.Poller().EnableWrite(c.fd)
+	}
+	return c.loop.Poller().EnableReadWrite(c.fd)
 }
 
 func (c *TCPConn) read() ([]byte, error) {
-	return c.lnet.opts.unPacket(c)
+	return c.listener.unPacket(c)
 }
 
+// handleWrite 可写事件，全部写出后取消可写事件
 func (c *TCPConn) handleWrite() error {
-	head, tail := c.outboundBuffer.LazyReadAll()
-	n, err := unix.Write(c.fd, head)
+	if !c.writeOut() {
+		c.notifyWritten()
+		return nil
+	}
+	var err error
+	if c.readPaused() {
+		err = c.loop.Poller().DisableReadWrite(c.fd)
+	} else {
+		err = c.loop.Poller().EnableRead(c.fd)
+	}
 	if err != nil {
-		if err == unix.EAGAIN {
-			return nil
-		}
-		return c.handleClose(c.fd)
+		limlog.Error("[EnableRead]", zap.Error(err))
 	}
-	c.outboundBuffer.Shift(n)
-	if len(head) == n && tail != nil {
-		n, err = unix.Write(c.fd, tail)
+	c.notifyWritten()
+	c.writeComplete()
+	c.onDrained()
+	return nil
+}
+
+// writeOut 按顺序写出输出buffer和排队的文件段，最后转发splice管道里的数据，返回是否已全部写出
+func (c *TCPConn) writeOut() bool {
+	for {
+		limit := c.outboundBuffer.Length()
+		if len(c.files) > 0 && c.files[0].gap < limit { // 只能写出文件段之前的数据
+			limit = c.files[0].gap
+		}
+		if limit > 0 {
+			head, tail := c.outboundBuffer.LazyRead(limit)
+			n, err := writev(c.fd, [][]byte{head, tail}, &c.iovecs) // head和tail一次写出
+			if err != nil {
+				if err == unix.EAGAIN {
+					c.lnet.metrics.EAGAIN(TransportTCP)
+					return false
+				}
+				c.listener.onError(c, err)
+				_ = c.closeWith(CloseWriteError, err)
+				return false
+			}
+			c.shiftOutbound(n)
+			if len(c.files) > 0 {
+				c.files[0].gap -= n
+				c.fileGap -= n
+			}
+			if n < limit { // socket缓冲区已满，等待下次可写
+				return false
+			}
+			continue
+		}
+		if len(c.files) == 0 {
+			break
+		}
+		done, err := c.sendFile(c.files[0])
 		if err != nil {
 			if err == unix.EAGAIN {
-				return nil
+				c.lnet.metrics.EAGAIN(TransportTCP)
+				return false
 			}
-			return c.handleClose(c.fd)
+			c.listener.onError(c, err)
+			_ = c.closeWith(CloseWriteError, err)
+			return false
+		}
+		if !done {
+			return false
 		}
-		c.outboundBuffer.Shift(n)
+		c.files[0].close()
+		c.files[0] = nil
+		c.files = c.files[1:]
 	}
-	if c.outboundBuffer.IsEmpty() {
-		err := c.loop.Poller().EnableRead(c.fd)
-		if err != nil {
-			limlog.Error("[EnableRead]", zap.Error(err))
+	if c.spliceFrom != nil {
+		_ = c.drainSplice()
+	}
+	return c.connected.Get() && !c.writePending()
+}
+
+// stage 暂存其他goroutine的写入（一个包），第一次暂存时通知eventloop
+func (c *TCPConn) stage(bufs [][]byte, seg *fileSegment, ref *Buffer, cb func(err error)) error {
+	c.stageMu.Lock()
+	if !c.connected.Get() {
+		c.stageMu.Unlock()
+		if seg != nil {
+			seg.close()
 		}
+		ref.Release()
+		return ErrConnectionClosed
+	}
+	for _, buf := range bufs {
+		c.staged = append(c.staged, stagedWrite{buf: buf})
+	}
+	if ref != nil || cb != nil { // 最后一个buffer写出后释放/回调
+		c.staged[len(c.staged)-1].ref = ref
+		c.staged[len(c.staged)-1].cb = cb
+	}
+	if seg != nil {
+		c.staged = append(c.staged, stagedWrite{seg: seg})
+	}
+	c.stagedPackets++
+	notify := !c.stageQueued
+	c.stageQueued = true
+	c.stageMu.Unlock()
+	if notify {
+		return c.loop.TriggerFlush(c)
 	}
 	return nil
 }
 
+// drainStaged 在eventloop里写出暂存的数据，连续的buffer合并成一次writev
+func (c *TCPConn) drainStaged() {
+	c.stageMu.Lock()
+	if !c.stageQueued {
+		c.stageMu.Unlock()
+		return
+	}
+	items := c.staged
+	packets := c.stagedPackets
+	c.staged, c.draining = c.draining[:0], nil
+	c.stagedPackets = 0
+	c.stageQueued = false
+	c.stageMu.Unlock()
+
+	if c.connected.Get() {
+		for i := 0; i < packets; i++ {
+			c.lnet.metrics.PacketOut(TransportTCP)
+		}
+	}
+	bufs := c.drainBufs[:0]
+	for i := range items {
+		if seg := items[i].seg; seg != nil {
+			if len(bufs) > 0 {
+				c.writev(bufs)
+				bufs = bufs[:0]
+			}
+			c.queueFile(seg)
+		} else {
+			bufs = append(bufs, items[i].buf)
+			if cb := items[i].cb; cb != nil { // 回调需要知道到此为止的数据是否写入成功
+				c.addWriteCallback(c.writev(bufs), cb)
+				bufs = bufs[:0]
+			}
+		}
+	}
+	if len(bufs) > 0 {
+		c.writev(bufs)
+	}
+	for i := range items { // 没写出的数据已经拷贝到outboundBuffer
+		items[i].ref.Release()
+		items[i] = stagedWrite{}
+	}
+	for i := range bufs {
+		bufs[i] = nil
+	}
+	c.drainBufs = bufs[:0]
+	c.stageMu.Lock()
+	c.draining = items[:0]
+	c.stageMu.Unlock()
+}
+
+// FlushWrites 写出暂存的数据，合并写模式下一轮事件循环结束时写出合并的数据
+func (c *TCPConn) FlushWrites() {
+	defer c.checkBudget() // 写不出去积压在输出buffer里的数据也计入内存
+	c.drainStaged()
+	if !c.dirty {
+		return
+	}
+	c.dirty = false
+	if !c.connected.Get() {
+		return
+	}
+	if c.loop.IsRing() {
+		if err := c.submitSend(); err != nil {
+			c.listener.onError(c, err)
+		}
+		return
+	}
+	if c.writeOut() {
+		c.notifyWritten()
+		c.onDrained()
+	} else if c.connected.Get() && c.writePending() {
+		c.notifyWritten()
+		if err := c.enableWrite(); err != nil {
+			c.listener.onError(c, err)
+		}
+	}
+}
+
 func (c *TCPConn) handleClose(fd int) error {
 
 	if c.connected.Get() {
 		c.connected.Set(false)
+		c.cancelCtx()
 
-		c.loop.DeleteFdInLoop(fd) // 删除eventloop里的此连接
+		c.stopSplice()
+		if c.loop.IsRing() {
+			c.loop.DeleteCompletionHandler(uint64(c.id))
+			// io_uring的操作持有socket的引用，需要先shutdown结束进行中的recv/send，close才能真正关闭连接
+			_ = unix.Shutdown(fd, unix.SHUT_RDWR)
+		} else {
+			c.loop.DeleteFdInLoop(fd) // 删除eventloop里的此连接
+		}
 
-		c.lnet.eventHandler.OnClose(c) // 连接关闭
+		if !c.proxyPending { // 等待PROXY协议头的连接还未触发过OnConnect
+			c.listener.handlerOf(c).OnClose(c) // 连接关闭
+		}
+		c.lnet.conns.Delete(c.id)
+		c.lnet.limiter.release(c.limitAddr)
+		c.lnet.metrics.ConnClosed(TransportTCP)
+		c.lnet.metrics.OutboundBuffered(-c.outboundBuffer.Length())
 
 		if err := unix.Close(c.fd); err != nil {
-			fmt.Println("handleClose111error.....")
-			limlog.Error("[close fd]", zap.Error(err))
+			c.Error("关闭连接的fd失败！", zap.Int64("id", c.id), zap.Error(err))
 		}
 		c.release() // 释放连接
 	}
@@ -205,57 +752,226 @@ func (c *TCPConn) handleClose(fd int) error {
 }
 
 func (c *TCPConn) write(buf []byte) {
-
 	if !c.connected.Get() {
 		return
 	}
-	if !c.outboundBuffer.IsEmpty() { // 如果输出buffer不为空，则写入到输出buffer里等下次event的时候真正写出去
-		_, _ = c.outboundBuffer.Write(buf)
-		return
+	c.lnet.metrics.PacketOut(TransportTCP)
+	_ = c.writev([][]byte{buf})
+}
+
+// writev 写出多个buffer，输出buffer为空时用一次writev直接写出，没写完的部分写入输出buffer
+// 返回nil表示数据已写出或者已进入输出buffer
+func (c *TCPConn) writev(bufs [][]byte) error {
+
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	if c.writeShut() {
+		c.listener.onError(c, ErrWriteClosed)
+		return ErrWriteClosed
+	}
+	for _, buf := range bufs {
+		c.queued += int64(len(buf))
+	}
+	if c.lnet.opts.WriteCoalescing { // 合并写，本轮事件循环结束时再写出
+		if !c.dirty && !c.writePending() { // 已有待写出的数据时会由可写事件写出
+			c.dirty = true
+			c.loop.MarkDirty(c)
+		}
+		for _, buf := range bufs {
+			_, _ = c.writeOutbound(buf)
+		}
+		return nil
+	}
+	if c.writePending() { // 如果还有待写出的数据，则写入到输出buffer里等下次event的时候真正写出去
+		for _, buf := range bufs {
+			_, _ = c.writeOutbound(buf)
+		}
+		return nil
 	}
 	// 如果输出buffer为空，则数据可以立马写出去
-	n, err := unix.Write(c.fd, buf)
+	n, err := writev(c.fd, bufs, &c.iovecs)
 	if err != nil {
 		if err == unix.EAGAIN {
 			c.Warn("EAGAIN！", zap.Any("conn", c))
-			_, _ = c.outboundBuffer.Write(buf)
-			_ = c.loop.Poller().EnableReadWrite(c.fd)
-			return
+			c.lnet.metrics.EAGAIN(TransportTCP)
+			for _, buf := range bufs {
+				_, _ = c.writeOutbound(buf)
+			}
+			_ = c.enableWrite()
+			return nil
 		}
-		err = c.handleClose(c.fd)
-		if err != nil {
+		c.listener.onError(c, err)
+		if cerr := c.closeWith(CloseWriteError, err); cerr != nil {
 			c.Error("关闭连接失败！", zap.Any("conn", c))
 		}
-		return
+		return err
 	}
-	if n == 0 {
-		_, err = c.outboundBuffer.Write(buf)
-		if err != nil {
-			c.Error("写到客户端缓存区失败！", zap.Error(err), zap.Any("conn", c))
+	c.written += int64(n)
+	c.lnet.metrics.BytesOut(TransportTCP, n)
+	for _, buf := range bufs { // 跳过已写出的部分
+		if n >= len(buf) {
+			n -= len(buf)
+			continue
 		}
-	} else if n < len(buf) {
-		_, err = c.outboundBuffer.Write(buf[n:])
+		_, err = c.writeOutbound(buf[n:])
 		if err != nil {
 			c.Error("写到客户端缓存区失败！", zap.Error(err), zap.Any("conn", c))
+			c.listener.onError(c, err)
 		}
+		n = 0
 	}
 	if c.outboundBuffer.Length() > 0 {
-		err = c.loop.Poller().EnableReadWrite(c.fd)
+		err = c.enableWrite()
 		if err != nil {
 			c.Error("EnableReadWrite is fail ！", zap.Error(err), zap.Any("conn", c))
 		}
 	}
+	return nil
+}
 
+// writeOutbound 写入输出buffer（需要时才从池里取），并记录积压的字节数
+func (c *TCPConn) writeOutbound(buf []byte) (int, error) {
+	if c.outboundBuffer == nil {
+		c.outboundBuffer = c.getBuffer()
+	}
+	if c.outboundBuffer.IsEmpty() {
+		c.writeStalledAt = time.Now()
+		c.setBacklogged(true)
+	}
+	size := c.outboundBuffer.Cap()
+	n, err := c.outboundBuffer.Write(buf)
+	c.lnet.bufferResized(c.outboundBuffer.Cap() - size)
+	c.lnet.metrics.OutboundBuffered(n)
+	return n, err
+}
+
+// shiftOutbound 已写出n个字节，移动输出buffer的下标，全部写出后放回池里
+func (c *TCPConn) shiftOutbound(n int) {
+	c.outboundBuffer.Shift(n)
+	c.written += int64(n)
+	c.lnet.metrics.BytesOut(TransportTCP, n)
+	c.lnet.metrics.OutboundBuffered(-n)
+	if !c.outboundBuffer.IsEmpty() {
+		c.writeStalledAt = time.Now()
+		return
+	}
+	c.setBacklogged(false)
+	if c.sendPending == 0 { // io_uring还在发送的buffer不能放回池里
+		c.putBuffer(c.outboundBuffer)
+		c.outboundBuffer = nil
+	}
+}
+
+// setBacklogged 记录连接的输出是否有积压，驱逐慢消费者时只遍历有积压的连接（只在所属eventloop里调用）
+func (c *TCPConn) setBacklogged(on bool) {
+	set := c.lnet.backlogged[c.loop]
+	if set == nil {
+		return
+	}
+	if on {
+		set[c] = struct{}{}
+	} else {
+		delete(set, c)
+	}
+}
+
+// inbound 需要写入时才从池里取inboundBuffer（空闲连接不占用buffer）
+func (c *TCPConn) inbound() *ringbuffer.RingBuffer {
+	if c.inboundBuffer == nil {
+		c.inboundBuffer = c.getBuffer()
+	}
+	return c.inboundBuffer
+}
+
+// writeInbound 写入inboundBuffer，并记录占用的内存
+func (c *TCPConn) writeInbound(buf []byte) (int, error) {
+	rb := c.inbound()
+	size := rb.Cap()
+	n, err := rb.Write(buf)
+	c.lnet.bufferResized(rb.Cap() - size)
+	return n, err
+}
+
+// releaseInbound inboundBuffer没有未处理的数据时放回池里
+func (c *TCPConn) releaseInbound() {
+	if c.inboundBuffer != nil && c.inboundBuffer.IsEmpty() {
+		c.putBuffer(c.inboundBuffer)
+		c.inboundBuffer = nil
+	}
+}
+
+func (c *TCPConn) getBuffer() *ringbuffer.RingBuffer {
+	rb := ringbuffer.Get()
+	c.lnet.bufferResized(rb.Cap())
+	return rb
+}
+
+func (c *TCPConn) putBuffer(rb *ringbuffer.RingBuffer) {
+	c.lnet.bufferResized(-rb.Cap())
+	ringbuffer.Put(rb)
+}
+
+// checkBudget 所有连接缓冲的内存超过限制时关闭还占用着buffer的连接
+func (c *TCPConn) checkBudget() {
+	if !c.connected.Get() || (c.inboundBuffer == nil && c.outboundBuffer == nil) || !c.lnet.limiter.overBudget() {
+		return
+	}
+	c.Warn("连接缓冲的内存超过限制，关闭连接！", zap.String("addr", c.addr), zap.Int("inbound", c.inboundBuffer.Length()), zap.Int("outbound", c.outboundBuffer.Length()))
+	c.listener.onError(c, ErrMemoryBudgetExceeded)
+	_ = c.closeWith(CloseMemoryBudget, ErrMemoryBudgetExceeded)
 }
 
 // 释放连接
 func (c *TCPConn) release() {
 	c.buffer = nil
-	c.ctx = nil
-	ringbuffer.Put(c.inboundBuffer)
-	ringbuffer.Put(c.outboundBuffer)
+	c.resetValues()
+	if c.inboundBuffer != nil {
+		c.putBuffer(c.inboundBuffer)
+	}
+	if c.outboundBuffer != nil {
+		if c.sendPending == 0 { // io_uring还在发送的buffer不能放回池里复用
+			c.putBuffer(c.outboundBuffer)
+		} else {
+			c.lnet.bufferResized(-c.outboundBuffer.Cap())
+		}
+	}
+	c.sending = nil
+	c.iovecs = nil
+	for _, f := range c.files {
+		f.close()
+	}
+	c.files = nil
+	c.fileChunk = nil
+	callbacks := c.writeCallbacks
+	c.writeCallbacks = nil
+	for _, cb := range callbacks { // 没写完的数据随连接关闭丢弃
+		cb.fn(ErrConnectionClosed)
+	}
+	c.stageMu.Lock()
+	staged := c.staged
+	for _, w := range staged {
+		if w.seg != nil {
+			w.seg.close()
+		}
+		w.ref.Release()
+	}
+	c.staged = nil
+	c.stagedPackets = 0
+	c.stageQueued = false
+	c.draining = nil
+	c.stageMu.Unlock()
+	for _, w := range staged {
+		if w.cb != nil {
+			w.cb(ErrConnectionClosed)
+		}
+	}
+	c.drainBufs = nil
+	c.spliceTo = nil
+	c.spliceFrom = nil
 	c.inboundBuffer = nil
 	c.outboundBuffer = nil
+	c.setBacklogged(false)
 	bytebuffer.Put(c.byteBuffer)
 	c.byteBuffer = nil
 }
@@ -267,7 +983,7 @@ func (c *TCPConn) GetID() int64 {
 	return c.id
 }
 
-// Read 读取数据
+// Read 读取数据，数据连续时直接返回inboundBuffer里的数据（零拷贝），只在调用期间有效
 func (c *TCPConn) Read() []byte {
 	if !c.connected.Get() {
 		return nil
@@ -275,8 +991,13 @@ func (c *TCPConn) Read() []byte {
 	if c.inboundBuffer.IsEmpty() {
 		return c.buffer
 	}
-	// 将当前临时的buffer与inboundBuffer的数据合并后返回（虚读 不改变ringbuffer的长度）
-	c.byteBuffer = c.inboundBuffer.WithByteBuffer(c.buffer)
+	head, tail := c.inboundBuffer.LazyReadAll()
+	if len(tail) == 0 {
+		return head
+	}
+	// 数据跨过了ringbuffer的末尾，合并后返回（虚读 不改变ringbuffer的长度）
+	bytebuffer.Put(c.byteBuffer) // 上一次合并的buffer放回池里
+	c.byteBuffer = c.inboundBuffer.ByteBuffer()
 	return c.byteBuffer.Bytes()
 }
 
@@ -288,36 +1009,48 @@ func (c *TCPConn) ResetBuffer() {
 	c.byteBuffer = nil
 }
 
-// ReadN 读取指定长度的数据
+// ReadN 读取指定长度的数据，数据连续时不拷贝
 func (c *TCPConn) ReadN(n int) (size int, buf []byte) {
-	inBufferLen := c.inboundBuffer.Length()
-	tempBufferLen := len(c.buffer)
-	if totalLen := inBufferLen + tempBufferLen; totalLen < n || n <= 0 {
-		n = totalLen
-	}
-	size = n
 	if c.inboundBuffer.IsEmpty() {
-		buf = c.buffer[:n]
-		return
+		if len(c.buffer) < n || n <= 0 {
+			n = len(c.buffer)
+		}
+		return n, c.buffer[:n]
 	}
-	head, tail := c.inboundBuffer.LazyRead(n)
+	head, tail := c.inboundBuffer.LazyReadAll()
+	if length := len(head) + len(tail); length < n || n <= 0 {
+		n = length
+	}
+	if n <= len(head) {
+		return n, head[:n]
+	}
+	bytebuffer.Put(c.byteBuffer) // 上一次合并的buffer放回池里
 	c.byteBuffer = bytebuffer.Get()
-	_, err := c.byteBuffer.Write(head)
-	if err != nil {
-		c.Warn("Write head fail", zap.Error(err))
+	_, _ = c.byteBuffer.Write(head)
+	_, _ = c.byteBuffer.Write(tail[:n-len(head)])
+	return n, c.byteBuffer.Bytes()
+}
+
+// Peek 查看前n个字节（n<=0表示全部）但不移动下标，数据跨过ringbuffer末尾时分成head和tail两段返回，不拷贝
+func (c *TCPConn) Peek(n int) (head, tail []byte) {
+	if c.inboundBuffer.IsEmpty() {
+		if len(c.buffer) < n || n <= 0 {
+			n = len(c.buffer)
+		}
+		return c.buffer[:n], nil
 	}
-	_, err = c.byteBuffer.Write(tail)
-	if err != nil {
-		c.Warn("Write head fail", zap.Error(err))
+	if n <= 0 {
+		return c.inboundBuffer.LazyReadAll()
 	}
-	if inBufferLen >= n {
-		buf = c.byteBuffer.Bytes()
-		return
+	return c.inboundBuffer.LazyRead(n)
+}
+
+// Discard 丢弃前n个字节，返回实际丢弃的字节数
+func (c *TCPConn) Discard(n int) int {
+	if n <= 0 {
+		return 0
 	}
-	restSize := n - inBufferLen
-	_, _ = c.byteBuffer.Write(c.buffer[:restSize])
-	buf = c.byteBuffer.Bytes()
-	return
+	return c.ShiftN(n)
 }
 
 // ShiftN 移动指定长度的下标
@@ -354,10 +1087,40 @@ func (c *TCPConn) Write(buf []byte) (err error) {
 	if !c.connected.Get() {
 		return ErrConnectionClosed
 	}
-	return c.loop.Trigger(func() error {
-		c.write(buf)
-		return nil
-	})
+	return c.stage([][]byte{c.listener.pack(c, buf)}, nil, nil, nil)
+}
+
+// WriteWithCallback 写数据，数据全部交给内核或者写失败时在eventloop里调用cb（不要在cb里阻塞）
+func (c *TCPConn) WriteWithCallback(buf []byte, cb func(err error)) (err error) {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	return c.stage([][]byte{c.listener.pack(c, buf)}, nil, nil, cb)
+}
+
+// WriteBuffer 写出buf（不拷贝），接管调用方的一个引用，写出后释放
+func (c *TCPConn) WriteBuffer(buf *Buffer) (err error) {
+	if !c.connected.Get() {
+		buf.Release()
+		return ErrConnectionClosed
+	}
+	return c.stage([][]byte{c.listener.pack(c, buf.Bytes())}, nil, buf, nil)
+}
+
+// Writev 写多个buffer，设置了封包协议时合并成一个包再封包（写出前不要修改bufs）
+func (c *TCPConn) Writev(bufs ...[]byte) (err error) {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	return c.stage(c.listener.packv(c, bufs), nil, nil, nil)
+}
+
+// Flush 合并写模式下立即写出已合并的数据（在之前的Write之后执行）
+func (c *TCPConn) Flush() error {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	return c.loop.TriggerFlush(c)
 }
 
 // Connected 是否已连接
@@ -375,28 +1138,229 @@ func (c *TCPConn) Close() error {
 	if !c.connected.Get() {
 		return ErrConnectionClosed
 	}
+	c.cause.set(CloseLocal, nil)
 	return c.loop.Trigger(func() error {
 		return c.handleClose(c.fd)
 	})
 }
 
-// Context 获取用户上下文内容
-func (c *TCPConn) Context() interface{} { return c.ctx }
+// CloseAfterFlush 不再读取，写完已写入的数据后关闭连接，超过timeout还没写完则直接关闭（原因为 CloseFlushTimeout）
+func (c *TCPConn) CloseAfterFlush(timeout time.Duration) error {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	if timeout > 0 {
+		c.lnet.timingWheel.AfterFunc(timeout, func() {
+			if c.connected.Get() {
+				_ = c.closeWithReason(CloseFlushTimeout)
+			}
+		})
+	}
+	return c.loop.Trigger(func() error {
+		if !c.connected.Get() || c.closePending {
+			return nil
+		}
+		c.drainStaged() // 之前Write的数据排在关闭之前
+		c.closePending = true
+		if !c.loop.IsRing() && c.writePending() {
+			if err := c.loop.Poller().EnableWrite(c.fd); err != nil {
+				c.Error("暂停读取失败！", zap.Error(err))
+			}
+		}
+		c.onDrained()
+		return nil
+	})
+}
 
-// SetContext 设置用户上下文内容
-func (c *TCPConn) SetContext(ctx interface{}) { c.ctx = ctx }
+// CloseWrite 写完已写入的数据后关闭写方向
+func (c *TCPConn) CloseWrite() error {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	return c.loop.Trigger(func() error {
+		if !c.connected.Get() || c.writeClosed {
+			return nil
+		}
+		c.drainStaged() // 之前Write的数据排在关闭之前
+		c.shutdownPending = true
+		c.onDrained()
+		return nil
+	})
+}
+
+// CloseReason 连接关闭的原因
+func (c *TCPConn) CloseReason() CloseReason {
+	reason, _ := c.cause.get()
+	return reason
+}
 
-// Status 自定义连接状态
-func (c *TCPConn) Status() int { return c.status }
+// CloseErr 导致连接关闭的错误
+func (c *TCPConn) CloseErr() error {
+	_, err := c.cause.get()
+	return err
+}
 
-// SetStatus 设置状态
-func (c *TCPConn) SetStatus(status int) { c.status = status }
+// closeWithReason 记录关闭原因后关闭连接（可在其他goroutine调用）
+func (c *TCPConn) closeWithReason(reason CloseReason) error {
+	c.cause.set(reason, nil)
+	return c.Close()
+}
 
-// Version 协议版本
-func (c *TCPConn) Version() uint8 { return c.version }
+// closeWith 在eventloop里记录关闭原因并关闭连接
+func (c *TCPConn) closeWith(reason CloseReason, err error) error {
+	c.cause.set(reason, err)
+	return c.handleClose(c.fd)
+}
 
-// SetVersion 设置连接的协议版本
-func (c *TCPConn) SetVersion(version uint8) { c.version = version }
+// handlePeerEOF 对端关闭了写方向，不再读取。实现了 HalfCloseHandler 时保持连接，否则写完已有的数据后关闭
+func (c *TCPConn) handlePeerEOF() error {
+	c.readClosed = true
+	if c.keepHalfOpen() {
+		c.listener.handlerOf(c).(HalfCloseHandler).OnPeerHalfClose(c)
+		if !c.connected.Get() {
+			return nil
+		}
+	}
+	if !c.loop.IsRing() {
+		var err error
+		if c.writePending() {
+			err = c.loop.Poller().EnableWrite(c.fd)
+		} else {
+			err = c.loop.Poller().DisableReadWrite(c.fd)
+		}
+		if err != nil {
+			c.Error("暂停读取失败！", zap.Error(err))
+		}
+	}
+	c.onDrained()
+	return nil
+}
+
+// onDrained 待写出的数据全部写完后执行等待中的CloseWrite，对端已关闭写方向时关闭连接
+func (c *TCPConn) onDrained() {
+	if !c.connected.Get() || c.writePending() || c.sendPending > 0 || c.dirty {
+		return
+	}
+	if c.closePending {
+		_ = c.closeWith(CloseLocal, nil)
+		return
+	}
+	if c.shutdownPending {
+		c.shutdownPending = false
+		c.writeClosed = true
+		if err := unix.Shutdown(c.fd, unix.SHUT_WR); err != nil {
+			c.listener.onError(c, err)
+		}
+	}
+	if !c.readClosed {
+		return
+	}
+	if !c.keepHalfOpen() || c.writeClosed {
+		_ = c.closeWith(ClosePeerEOF, nil)
+	}
+}
+
+// addWriteCallback 输出流写到当前位置时调用cb，err不为nil（数据没有进入输出流）时立即调用
+func (c *TCPConn) addWriteCallback(err error, cb func(err error)) {
+	if err != nil {
+		cb(err)
+		return
+	}
+	if !c.connected.Get() { // 写出时出错已关闭连接
+		cb(ErrConnectionClosed)
+		return
+	}
+	if c.written >= c.queued {
+		cb(nil)
+		return
+	}
+	c.writeCallbacks = append(c.writeCallbacks, writeCallback{end: c.queued, fn: cb})
+}
+
+// notifyWritten 调用已写到的回调
+func (c *TCPConn) notifyWritten() {
+	n := 0
+	for n < len(c.writeCallbacks) && c.writeCallbacks[n].end <= c.written {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	done := c.writeCallbacks[:n]
+	c.writeCallbacks = c.writeCallbacks[n:]
+	for _, cb := range done {
+		cb.fn(nil)
+	}
+}
+
+// writeComplete 积压的数据全部写出后通知 WriteCompleteHandler
+func (c *TCPConn) writeComplete() {
+	if !c.connected.Get() || c.writePending() || c.sendPending > 0 {
+		return
+	}
+	if h, ok := c.listener.handlerOf(c).(WriteCompleteHandler); ok {
+		h.OnWriteComplete(c)
+	}
+}
+
+// keepHalfOpen 对端关闭写方向后是否保持连接（EventHandler实现了 HalfCloseHandler）
+func (c *TCPConn) keepHalfOpen() bool {
+	_, ok := c.listener.handlerOf(c).(HalfCloseHandler)
+	return ok && !c.proxyPending
+}
+
+// socketError 读取socket上待处理的错误（SO_ERROR）
+func socketError(fd int) error {
+	errno, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return unix.Errno(errno)
+	}
+	return nil
+}
+
+// bufferMemory 连接的输入输出buffer占用的内存
+func (c *TCPConn) bufferMemory() int {
+	return c.inboundBuffer.Cap() + c.outboundBuffer.Cap()
+}
 
 // GetAddr 获取连接地址
 func (c *TCPConn) GetAddr() string { return c.addr }
+
+// ProxyHeader 连接的PROXY协议头，未使用PROXY协议返回nil
+func (c *TCPConn) ProxyHeader() *proxyproto.Header { return c.proxyHeader }
+
+// LocalAddr 本地地址（使用PROXY协议时为代理记录的目标地址）
+func (c *TCPConn) LocalAddr() net.Addr {
+	if c.proxyHeader != nil && c.proxyHeader.DstAddr != nil {
+		return c.proxyHeader.DstAddr
+	}
+	return c.localAddr
+}
+
+// RemoteAddr 对端地址（使用PROXY协议时为真实客户端地址）
+func (c *TCPConn) RemoteAddr() net.Addr {
+	if c.proxyHeader != nil && c.proxyHeader.SrcAddr != nil {
+		return c.proxyHeader.SrcAddr
+	}
+	return c.remoteAddr
+}
+
+// Listener 连接来自的监听地址
+func (c *TCPConn) Listener() string { return c.listener.addr }
+
+// Transport 连接的传输类型
+func (c *TCPConn) Transport() Transport { return TransportTCP }
+
+// ConnectTime 连接建立的时间
+func (c *TCPConn) ConnectTime() time.Time { return c.connectTime }
+
+// TLSState tcp连接暂不支持TLS，总是返回nil
+func (c *TCPConn) TLSState() *tls.ConnectionState { return nil }
+
+// IdleTime 连接闲置时长
+func (c *TCPConn) IdleTime() time.Duration {
+	return time.Since(time.Unix(c.activeTime.Get(), 0))
+}
@@ -0,0 +1,153 @@
+package limnet
+
+import (
+	"bufio"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var errDecode = errors.New("decode error")
+
+// sessionUnPacket 按换行拆包，err行返回普通错误，bad行违反协议
+func sessionUnPacket(c Conn) ([]byte, error) {
+	data, err := strictLineUnPacket(c)
+	if err == nil && string(data) == "err" {
+		return nil, errDecode
+	}
+	return data, err
+}
+
+// session 工厂为每个连接创建的处理者
+type session struct {
+	events chan string
+}
+
+func (s *session) OnPacket(c Conn, data []byte) []byte {
+	s.events <- "packet:" + string(data)
+	return append([]byte("session:"), append(data, '\n')...)
+}
+
+func (s *session) OnError(c Conn, err error) {
+	s.events <- "error:" + err.Error()
+}
+
+func (s *session) OnProtocolViolation(c Conn, err error) []byte {
+	s.events <- "violation"
+	return []byte("session bye\n")
+}
+
+func (s *session) OnClose(c Conn) {
+	s.events <- "close:" + string(c.CloseReason())
+}
+
+// factoryHandler 监听器的EventHandler，只应该收到OnConnect
+type factoryHandler struct {
+	DefaultEventHandler
+	session  *session
+	connects chan Conn
+	events   chan string
+}
+
+func newFactoryHandler() *factoryHandler {
+	return &factoryHandler{
+		session:  &session{events: make(chan string, 10)},
+		connects: make(chan Conn, 1),
+		events:   make(chan string, 10),
+	}
+}
+
+func (h *factoryHandler) NewHandler(c Conn) ConnHandler { return h.session }
+
+func (h *factoryHandler) OnConnect(c Conn) { h.connects <- c }
+
+func (h *factoryHandler) OnPacket(c Conn, data []byte) []byte {
+	h.events <- "packet"
+	return nil
+}
+
+func (h *factoryHandler) OnError(c Conn, err error) { h.events <- "error" }
+
+func (h *factoryHandler) OnProtocolViolation(c Conn, err error) []byte {
+	h.events <- "violation"
+	return nil
+}
+
+func (h *factoryHandler) OnClose(c Conn) { h.events <- "close" }
+
+// expectSessionEvents 连接的事件都交给工厂创建的处理者，EventHandler收不到
+func expectSessionEvents(t *testing.T, h *factoryHandler, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		if e := recv(t, h.session.events); e != w {
+			t.Fatalf("expect %q but got %q", w, e)
+		}
+	}
+	select {
+	case e := <-h.events:
+		t.Fatalf("expect no event on EventHandler but got %q", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConnHandlerFactory(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := newFactoryHandler()
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(sessionUnPacket), WithConnHandlerFactory(h))
+		conn := dial(t, addr)
+		recv(t, h.connects)
+		r := bufio.NewReader(conn)
+
+		if _, err := conn.Write([]byte("hi\n")); err != nil {
+			t.Fatal(err)
+		}
+		if line := readLine(t, r); line != "session:hi" {
+			t.Fatalf("expect session:hi but got %q", line)
+		}
+		if _, err := conn.Write([]byte("err\n")); err != nil {
+			t.Fatal(err)
+		}
+		expectSessionEvents(t, h, "packet:hi", "error:"+errDecode.Error())
+
+		if _, err := conn.Write([]byte("bad\n")); err != nil {
+			t.Fatal(err)
+		}
+		if line := readLine(t, r); line != "session bye" {
+			t.Fatalf("expect session bye but got %q", line)
+		}
+		expectSessionEvents(t, h, "violation", "close:"+string(CloseProtocolViolation))
+	})
+}
+
+func TestConnHandlerFactory_WS(t *testing.T) {
+	h := newFactoryHandler()
+	startServer(t, h, WithWSAddr("127.0.0.1:17154"), WithUnPacket(sessionUnPacket), WithConnHandlerFactory(h))
+	conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:17154", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	recv(t, h.connects)
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+
+	if err = conn.WriteMessage(websocket.BinaryMessage, []byte("hi\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "session:hi\n" {
+		t.Fatalf("expect session:hi but got %q, %v", msg, err)
+	}
+	if err = conn.WriteMessage(websocket.BinaryMessage, []byte("err\n")); err != nil {
+		t.Fatal(err)
+	}
+	expectSessionEvents(t, h, "packet:hi", "error:"+errDecode.Error())
+
+	if err = conn.WriteMessage(websocket.BinaryMessage, []byte("bad\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "session bye\n" {
+		t.Fatalf("expect session bye but got %q, %v", msg, err)
+	}
+	expectSessionEvents(t, h, "violation", "close:"+string(CloseProtocolViolation))
+}
@@ -0,0 +1,127 @@
+package limnet
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// connectHandler 把建立的连接发到conns
+type connectHandler struct {
+	DefaultEventHandler
+	conns chan Conn
+}
+
+func (h *connectHandler) OnConnect(c Conn) { h.conns <- c }
+
+// expectPeerInfo 校验连接的地址、来源监听器、传输类型和建立时间
+func expectPeerInfo(t *testing.T, c Conn, client net.Conn, listener string, transport Transport, start time.Time) {
+	t.Helper()
+	if c.LocalAddr() == nil || c.LocalAddr().String() != client.RemoteAddr().String() {
+		t.Errorf("expect local addr %s but got %v", client.RemoteAddr(), c.LocalAddr())
+	}
+	if c.RemoteAddr() == nil || c.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Errorf("expect remote addr %s but got %v", client.LocalAddr(), c.RemoteAddr())
+	}
+	if _, ok := c.RemoteAddr().(*net.TCPAddr); !ok {
+		t.Errorf("expect *net.TCPAddr but got %T", c.RemoteAddr())
+	}
+	if c.Listener() != listener {
+		t.Errorf("expect listener %s but got %s", listener, c.Listener())
+	}
+	if c.Transport() != transport {
+		t.Errorf("expect transport %v but got %v", transport, c.Transport())
+	}
+	if c.ConnectTime().Before(start) || c.ConnectTime().After(time.Now()) {
+		t.Errorf("unexpected connect time %v", c.ConnectTime())
+	}
+}
+
+func TestConn_PeerInfo(t *testing.T) {
+	h := &connectHandler{conns: make(chan Conn, 1)}
+	l, addr := startServer(t, h, WithWSAddr("127.0.0.1:17156"))
+	start := time.Now()
+
+	conn := dial(t, addr)
+	c := recv(t, h.conns)
+	expectPeerInfo(t, c, conn, l.tcp.listener.addr, TransportTCP, start)
+	if c.TLSState() != nil {
+		t.Error("expect no tls state on tcp conn")
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:17156", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	c = recv(t, h.conns)
+	expectPeerInfo(t, c, ws.UnderlyingConn(), "127.0.0.1:17156", TransportWS, start)
+	if c.TLSState() != nil {
+		t.Error("expect no tls state on ws conn")
+	}
+}
+
+func TestTCPConn_StagedWrite(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := &connectHandler{conns: make(chan Conn, 1)}
+		_, addr := startServer(t, h, WithPoller(backend))
+		conn := dial(t, addr)
+		c := recv(t, h.conns).(*TCPConn)
+
+		// eventloop忙的时候多次写入只入队一次，eventloop空闲后一次写出
+		started, block := make(chan struct{}), make(chan struct{})
+		if err := c.loop.Trigger(func() error {
+			close(started)
+			<-block
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		recv(t, started)
+		const writers, perWriter = 8, 100
+		var wg sync.WaitGroup
+		for g := 0; g < writers; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < perWriter; i++ {
+					if err := c.Write([]byte(fmt.Sprintf("%d:%d\n", g, i))); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		if n := c.loop.Stats().JobQueueLen; n != 1 {
+			t.Fatalf("expect one queued flush but got %d jobs", n)
+		}
+		close(block)
+
+		// 每个goroutine的写入按顺序到达
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		r := bufio.NewReader(conn)
+		next := make([]int, writers)
+		for n := 0; n < writers*perWriter; n++ {
+			var g, i int
+			if _, err := fmt.Sscanf(readLine(t, r), "%d:%d", &g, &i); err != nil {
+				t.Fatal(err)
+			}
+			if i != next[g] {
+				t.Fatalf("writer %d: expect %d but got %d", g, next[g], i)
+			}
+			next[g]++
+		}
+
+		_ = c.Close()
+		expectEOF(t, r)
+		if err := c.Write([]byte("closed\n")); err != ErrConnectionClosed {
+			t.Fatalf("expect %v but got %v", ErrConnectionClosed, err)
+		}
+	})
+}
@@ -1,12 +1,20 @@
 package limnet
 
 import (
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/tangtaoit/limnet/pkg/bytebuffer"
+	"github.com/tangtaoit/limnet/pkg/eventloop"
 	"github.com/tangtaoit/limnet/pkg/limlog"
+	"github.com/tangtaoit/limnet/pkg/limutil"
 	"github.com/tangtaoit/limnet/pkg/limutil/sync/atomic"
+	"github.com/tangtaoit/limnet/pkg/proxyproto"
 	"github.com/tangtaoit/limnet/pkg/ringbuffer"
 	"go.uber.org/zap"
 )
@@ -16,34 +24,67 @@ type WSConn struct {
 	id            int64
 	conn          *websocket.Conn
 	lnet          *LIMNet
+	listener      *Listener              // 连接来自的监听器
 	inboundBuffer *ringbuffer.RingBuffer // 来自客户端的数据
 	connected     atomic.Bool
 	activeTime    atomic.Int64 // 连接最后一次活动时间，单位秒
 	limlog.Log
 	buffer     []byte                 // inbound的临时buffer
 	byteBuffer *bytebuffer.ByteBuffer // 临时读到的buffer
-	ctx        interface{}            // 用户自定义的内容
-	status     int                    // 用户自定义的连接状态
-	version    uint8                  // 连接使用协议的版本
-	addr       string
+	connValues
+	addr          string               // 客户端地址（信任代理转发时为真实客户端地址）
+	peerAddr      string               // socket的对端地址
+	packetLimiter *limutil.TokenBucket // 包速率限制，未开启为nil
+	remoteAddr    net.Addr
+	connectTime   time.Time            // 连接建立的时间
+	tlsState      *tls.ConnectionState // TLS连接状态，非TLS为nil
+	memory        atomic.Int64         // inboundBuffer占用的内存
+	writingSince  atomic.Int64         // 正在写的消息开始写的时间（unix纳秒），没有在写为0
+	writingBytes  atomic.Int64         // 正在写的消息字节数
+	cause         closeCause           // 连接关闭的原因
+	closed        chan struct{}        // handleClose执行完后关闭，msgLoop等它关闭后再释放连接
+	writeMu       sync.Mutex           // websocket只允许一个goroutine同时写，所有写conn的地方都要持有
 }
 
-// NewWSConn 创建websocket连接
+// NewWSConn 创建默认websocket监听器的连接并开始读取消息
 func NewWSConn(id int64, conn *websocket.Conn, lnet *LIMNet) *WSConn {
+	w := newWSConn(id, conn, conn.RemoteAddr().String(), lnet.defaultListener(TransportWS))
+	go w.msgLoop()
+	return w
+}
+
+func newWSConn(id int64, conn *websocket.Conn, addr string, ln *Listener) *WSConn {
+	lnet := ln.lnet
 	w := &WSConn{
 		id:            id,
 		Log:           limlog.NewLIMLog("WSConn"),
 		conn:          conn,
 		lnet:          lnet,
-		inboundBuffer: ringbuffer.Get(),
+		listener:      ln,
+		packetLimiter: newPacketLimiter(lnet.opts),
+		closed:        make(chan struct{}),
+	}
+	w.addr = addr
+	w.peerAddr = conn.RemoteAddr().String()
+	w.remoteAddr = conn.RemoteAddr()
+	if addr != w.peerAddr { // 信任代理转发的真实客户端地址（只有ip）
+		if ip := net.ParseIP(addr); ip != nil {
+			w.remoteAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+	w.connectTime = time.Now()
+	if tlsConn, ok := conn.UnderlyingConn().(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		w.tlsState = &state
+	}
+	if max := lnet.opts.MaxInboundBuffer; max > 0 { // 单个消息超过限制时ReadMessage会返回错误并关闭连接
+		conn.SetReadLimit(int64(max))
 	}
-	w.addr = conn.RemoteAddr().String()
 	w.connected.Set(true)
-	if lnet.opts.ConnIdleTime > 0 {
-		_ = w.activeTime.Swap(int(time.Now().Unix()))
-		lnet.timingWheel.AfterFunc(lnet.opts.ConnIdleTime, w.closeTimeoutConn())
+	_ = w.activeTime.Swap(int(time.Now().Unix()))
+	if idleTime := ln.idleTime(); idleTime > 0 {
+		lnet.timingWheel.AfterFunc(idleTime, w.closeTimeoutConn())
 	}
-	go w.msgLoop()
 	return w
 }
 
@@ -54,66 +95,224 @@ func (c *WSConn) closeTimeoutConn() func() {
 		}
 		now := time.Now()
 		intervals := now.Sub(time.Unix(c.activeTime.Get(), 0))
-		if intervals >= c.lnet.opts.ConnIdleTime {
-			_ = c.Close()
+		idleTime := c.listener.idleTime()
+		if intervals >= idleTime {
+			_ = c.closeWithReason(CloseIdleTimeout)
 		} else {
-			c.lnet.timingWheel.AfterFunc(c.lnet.opts.ConnIdleTime-intervals, c.closeTimeoutConn())
+			c.lnet.timingWheel.AfterFunc(idleTime-intervals, c.closeTimeoutConn())
 		}
 	}
 }
 
 func (c *WSConn) msgLoop() {
+	defer func() { // 释放连接只在这里做，且在OnClose之后（连接可能在其他goroutine里关闭）
+		<-c.closed
+		c.release()
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			err := eventloop.NewPanicError(r)
+			c.Warn("WSConn处理消息遇到异常，请检查代码！", zap.Error(err))
+			c.listener.onError(c, err)
+			_ = c.closeWith(CloseReadError, err)
+		}
+	}()
 	for true {
 		_, data, err := c.conn.ReadMessage()
+		if err == websocket.ErrReadLimit { // 单个消息超过 Options.MaxInboundBuffer
+			c.protocolViolation(ErrInboundBufferExceeded)
+			return
+		}
 		if err != nil {
 			c.Debug("客户端断开", zap.Error(err))
-			c.handleClose()
+			c.closeWith(readCloseReason(err))
 			return
 		}
-		if c.lnet.opts.ConnIdleTime > 0 {
-			_ = c.activeTime.Swap(int(time.Now().Unix()))
+		_ = c.activeTime.Swap(int(time.Now().Unix()))
+		c.lnet.metrics.BytesIn(TransportWS, len(data))
+		if c.inboundBuffer.IsEmpty() {
+			c.buffer = data
+		} else { // 追加到未处理完的数据后面，保证c.buffer不为空时inboundBuffer为空
+			c.writeInbound(data)
 		}
-		c.buffer = data
-		for packet, _ := c.read(); packet != nil; packet, _ = c.read() {
-			out := c.lnet.eventHandler.OnPacket(c, packet)
+		for {
+			packet, err := c.read()
+			if err != nil {
+				if c.listener.decodeError(c, err) {
+					c.protocolViolation(err)
+					return
+				}
+				break
+			}
+			if packet == nil {
+				break
+			}
+			if !c.waitPacketRate() {
+				return
+			}
+			c.lnet.metrics.PacketIn(TransportWS)
+			out, buf := c.listener.onPacket(c, packet)
 			if len(out) > 0 {
-				c.write(out)
+				if err = c.write(c.listener.pack(c, out)); err != nil {
+					c.listener.onError(c, err)
+				}
+			}
+			buf.Release()
+			if !c.connected.Get() {
+				return
 			}
 		}
+		// 剩余不足一个包的数据放入inboundBuffer等待下一个消息
+		if c.listener.inboundExceeded(c.inboundBuffer.Length() + len(c.buffer)) {
+			c.protocolViolation(ErrInboundBufferExceeded)
+			return
+		}
+		if len(c.buffer) > 0 {
+			c.writeInbound(c.buffer)
+		}
+		c.buffer = nil
+		if c.inboundBuffer != nil && c.inboundBuffer.IsEmpty() { // 没有未处理的数据时放回池里
+			c.bufferResized(-c.inboundBuffer.Cap())
+			ringbuffer.Put(c.inboundBuffer)
+			c.inboundBuffer = nil
+		}
+		if c.inboundBuffer != nil && c.lnet.limiter.overBudget() {
+			c.Warn("连接缓冲的内存超过限制，关闭连接！", zap.String("addr", c.addr), zap.Int("inbound", c.inboundBuffer.Length()))
+			c.listener.onError(c, ErrMemoryBudgetExceeded)
+			c.closeWith(CloseMemoryBudget, ErrMemoryBudgetExceeded)
+			return
+		}
+	}
+}
+
+// writeInbound 写入inboundBuffer（需要时才从池里取），并记录占用的内存
+func (c *WSConn) writeInbound(buf []byte) {
+	if c.inboundBuffer == nil {
+		c.inboundBuffer = ringbuffer.Get()
+		c.bufferResized(c.inboundBuffer.Cap())
+	}
+	size := c.inboundBuffer.Cap()
+	_, _ = c.inboundBuffer.Write(buf)
+	c.bufferResized(c.inboundBuffer.Cap() - size)
+}
+
+// startWrite 记录正在写的消息，写被对端阻塞时用于判断写出停滞
+func (c *WSConn) startWrite(size int) {
+	_ = c.writingBytes.Swap(size)
+	_ = c.writingSince.Swap(int(time.Now().UnixNano()))
+}
+
+func (c *WSConn) endWrite() {
+	_ = c.writingSince.Swap(0)
+	_ = c.writingBytes.Swap(0)
+}
+
+// bufferResized 记录inboundBuffer占用的内存变化
+func (c *WSConn) bufferResized(delta int) {
+	c.memory.Add(delta)
+	c.lnet.bufferResized(delta)
+}
+
+// protocolViolation 违反协议，写出告别数据后关闭连接
+func (c *WSConn) protocolViolation(err error) {
+	if out := c.listener.goodbye(c, err); len(out) > 0 {
+		_ = c.write(out)
+	}
+	_ = c.closeWith(CloseProtocolViolation, err)
+}
+
+// waitPacketRate 超过包速率时阻塞等待（暂停读取）或关闭连接，连接被关闭返回false
+func (c *WSConn) waitPacketRate() bool {
+	if c.packetLimiter == nil {
+		return true
+	}
+	for !c.packetLimiter.Allow() {
+		if c.lnet.opts.PacketRateAction == RateActionClose {
+			c.Warn("超过包速率限制，关闭连接！", zap.String("addr", c.addr))
+			_ = c.closeWith(ClosePacketRate, nil)
+			return false
+		}
+		time.Sleep(c.packetLimiter.Wait())
 	}
+	return true
 }
 
 func (c *WSConn) write(buf []byte) error {
 	if !c.connected.Get() {
 		return nil
 	}
+	c.writeMu.Lock()
+	c.startWrite(len(buf))
 	err := c.conn.WriteMessage(websocket.BinaryMessage, buf)
+	c.endWrite()
+	c.writeMu.Unlock()
 	if err != nil {
 		return err
 	}
+	c.lnet.metrics.PacketOut(TransportWS)
+	c.lnet.metrics.BytesOut(TransportWS, len(buf))
 	return nil
 }
 
-func (c *WSConn) handleClose() error {
-	if c.connected.Get() {
-		c.connected.Set(false)
+func (c *WSConn) writev(bufs [][]byte) error {
+	if !c.connected.Get() {
+		return nil
+	}
+	size := 0
+	for _, buf := range bufs {
+		size += len(buf)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.startWrite(size)
+	defer c.endWrite()
+	w, err := c.conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, buf := range bufs {
+		if _, err = w.Write(buf); err != nil {
+			_ = w.Close()
+			return err
+		}
+		n += len(buf)
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	c.lnet.metrics.PacketOut(TransportWS)
+	c.lnet.metrics.BytesOut(TransportWS, n)
+	return nil
+}
 
-		c.lnet.eventHandler.OnClose(c) // 连接关闭
-		c.conn.Close()
-		c.release() // 释放连接
+func (c *WSConn) handleClose() error {
+	if !c.connected.CompareAndSwap(true, false) { // 已经关闭过
+		return nil
 	}
+	c.cancelCtx()
+
+	c.listener.handlerOf(c).OnClose(c) // 连接关闭
+	c.lnet.conns.Delete(c.id)
+	c.lnet.limiter.release(c.addr)
+	c.lnet.metrics.ConnClosed(TransportWS)
+	c.conn.Close() // msgLoop的ReadMessage会返回错误并退出
+	close(c.closed)
 	return nil
 }
 
 func (c *WSConn) read() ([]byte, error) {
-	return c.lnet.opts.unPacket(c)
+	return c.listener.unPacket(c)
 }
 
 func (c *WSConn) release() {
 	c.buffer = nil
-	c.ctx = nil
-	ringbuffer.Put(c.inboundBuffer)
-	c.inboundBuffer = nil
+	c.resetValues()
+	if c.inboundBuffer != nil {
+		c.bufferResized(-c.inboundBuffer.Cap())
+		ringbuffer.Put(c.inboundBuffer)
+		c.inboundBuffer = nil
+	}
 	bytebuffer.Put(c.byteBuffer)
 	c.byteBuffer = nil
 }
@@ -133,8 +332,13 @@ func (c *WSConn) Read() []byte {
 	if c.inboundBuffer.IsEmpty() {
 		return c.buffer
 	}
-	// 将当前临时的buffer与inboundBuffer的数据合并后返回（虚读 不改变ringbuffer的长度）
-	c.byteBuffer = c.inboundBuffer.WithByteBuffer(c.buffer)
+	head, tail := c.inboundBuffer.LazyReadAll()
+	if len(tail) == 0 {
+		return head
+	}
+	// 数据跨过了ringbuffer的末尾，合并后返回（虚读 不改变ringbuffer的长度）
+	bytebuffer.Put(c.byteBuffer) // 上一次合并的buffer放回池里
+	c.byteBuffer = c.inboundBuffer.ByteBuffer()
 	return c.byteBuffer.Bytes()
 }
 
@@ -148,34 +352,46 @@ func (c *WSConn) ResetBuffer() {
 
 // ReadN 读取指定长度的数据
 func (c *WSConn) ReadN(n int) (size int, buf []byte) {
-	inBufferLen := c.inboundBuffer.Length()
-	tempBufferLen := len(c.buffer)
-	if totalLen := inBufferLen + tempBufferLen; totalLen < n || n <= 0 {
-		n = totalLen
-	}
-	size = n
 	if c.inboundBuffer.IsEmpty() {
-		buf = c.buffer[:n]
-		return
+		if len(c.buffer) < n || n <= 0 {
+			n = len(c.buffer)
+		}
+		return n, c.buffer[:n]
+	}
+	head, tail := c.inboundBuffer.LazyReadAll()
+	if length := len(head) + len(tail); length < n || n <= 0 {
+		n = length
+	}
+	if n <= len(head) {
+		return n, head[:n]
 	}
-	head, tail := c.inboundBuffer.LazyRead(n)
+	bytebuffer.Put(c.byteBuffer) // 上一次合并的buffer放回池里
 	c.byteBuffer = bytebuffer.Get()
-	_, err := c.byteBuffer.Write(head)
-	if err != nil {
-		c.Warn("Write head fail", zap.Error(err))
+	_, _ = c.byteBuffer.Write(head)
+	_, _ = c.byteBuffer.Write(tail[:n-len(head)])
+	return n, c.byteBuffer.Bytes()
+}
+
+// Peek 查看前n个字节但不移动下标
+func (c *WSConn) Peek(n int) (head, tail []byte) {
+	if c.inboundBuffer.IsEmpty() {
+		if len(c.buffer) < n || n <= 0 {
+			n = len(c.buffer)
+		}
+		return c.buffer[:n], nil
 	}
-	_, err = c.byteBuffer.Write(tail)
-	if err != nil {
-		c.Warn("Write head fail", zap.Error(err))
+	if n <= 0 {
+		return c.inboundBuffer.LazyReadAll()
 	}
-	if inBufferLen >= n {
-		buf = c.byteBuffer.Bytes()
-		return
+	return c.inboundBuffer.LazyRead(n)
+}
+
+// Discard 丢弃前n个字节
+func (c *WSConn) Discard(n int) int {
+	if n <= 0 {
+		return 0
 	}
-	restSize := n - inBufferLen
-	_, _ = c.byteBuffer.Write(c.buffer[:restSize])
-	buf = c.byteBuffer.Bytes()
-	return
+	return c.ShiftN(n)
 }
 
 // ShiftN 移动指定长度的下标
@@ -212,7 +428,81 @@ func (c *WSConn) Write(buf []byte) error {
 	if !c.connected.Get() {
 		return ErrConnectionClosed
 	}
-	return c.write(buf)
+	return c.write(c.listener.pack(c, buf))
+}
+
+// WriteWithCallback 写数据，websocket的写入是同步的，返回前调用cb
+func (c *WSConn) WriteWithCallback(buf []byte, cb func(err error)) error {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	err := c.write(c.listener.pack(c, buf))
+	cb(err)
+	return err
+}
+
+// WriteBuffer 写出buf，websocket的写入是同步的，返回前释放buf
+func (c *WSConn) WriteBuffer(buf *Buffer) error {
+	defer buf.Release()
+	return c.Write(buf.Bytes())
+}
+
+// Flush websocket连接的写入是同步的，不需要flush
+func (c *WSConn) Flush() error {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	return nil
+}
+
+// SendFile websocket需要分帧，文件段按帧读取作为一个消息写出（不会整段读到内存里）
+// 写到一半读文件失败时消息已经不完整，关闭连接
+func (c *WSConn) SendFile(f *os.File, offset, count int64) error {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	count, err := fileSegmentSize(f, offset, count)
+	if err != nil || count == 0 {
+		return err
+	}
+	n, broken, err := c.writeFile(f, offset, count)
+	if broken { // 消息已经不完整（在写锁外关闭，OnClose里可能还会写）
+		_ = c.closeWith(CloseWriteError, err)
+	}
+	if err != nil {
+		return err
+	}
+	c.lnet.metrics.PacketOut(TransportWS)
+	c.lnet.metrics.BytesOut(TransportWS, int(n))
+	return nil
+}
+
+// writeFile 把文件段作为一个消息写出，broken表示消息已经写了一部分，连接不能再用
+func (c *WSConn) writeFile(f *os.File, offset, count int64) (n int64, broken bool, err error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.startWrite(int(count))
+	defer c.endWrite()
+	w, err := c.conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return 0, false, err
+	}
+	n, err = io.Copy(w, io.NewSectionReader(f, offset, count))
+	if err == nil && n < count { // 文件在发送期间被截断
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return n, true, err
+	}
+	return n, false, w.Close()
+}
+
+// Writev 写多个buffer，作为一个websocket消息写出
+func (c *WSConn) Writev(bufs ...[]byte) error {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	return c.writev(c.listener.packv(c, bufs))
 }
 
 // Close 关闭连接
@@ -220,26 +510,89 @@ func (c *WSConn) Close() error {
 	if !c.connected.Get() {
 		return ErrConnectionClosed
 	}
+	c.cause.set(CloseLocal, nil)
 	return c.handleClose()
 }
 
-// Context 获取用户上下文内容
-func (c *WSConn) Context() interface{} { return c.ctx }
+// CloseAfterFlush websocket连接的写入是同步的，没有待写出的数据，直接关闭连接
+func (c *WSConn) CloseAfterFlush(timeout time.Duration) error {
+	return c.Close()
+}
+
+// CloseWrite 发送websocket关闭帧，之后不能再写，对端回复关闭帧后连接关闭
+func (c *WSConn) CloseWrite() error {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+}
 
-// SetContext 设置用户上下文内容
-func (c *WSConn) SetContext(ctx interface{}) { c.ctx = ctx }
+// CloseReason 连接关闭的原因
+func (c *WSConn) CloseReason() CloseReason {
+	reason, _ := c.cause.get()
+	return reason
+}
 
-// Status 自定义连接状态
-func (c *WSConn) Status() int { return c.status }
+// CloseErr 导致连接关闭的错误
+func (c *WSConn) CloseErr() error {
+	_, err := c.cause.get()
+	return err
+}
 
-// SetStatus 设置状态
-func (c *WSConn) SetStatus(status int) { c.status = status }
+// closeWithReason 记录关闭原因后关闭连接
+func (c *WSConn) closeWithReason(reason CloseReason) error {
+	c.cause.set(reason, nil)
+	return c.Close()
+}
 
-// Version 协议版本
-func (c *WSConn) Version() uint8 { return c.version }
+// closeWith 记录关闭原因并关闭连接
+func (c *WSConn) closeWith(reason CloseReason, err error) error {
+	c.cause.set(reason, err)
+	return c.handleClose()
+}
 
-// SetVersion 设置连接的协议版本
-func (c *WSConn) SetVersion(version uint8) { c.version = version }
+// readCloseReason ReadMessage返回的错误对应的关闭原因，正常的关闭帧不算错误
+func readCloseReason(err error) (CloseReason, error) {
+	if ce, ok := err.(*websocket.CloseError); ok {
+		if ce.Code == websocket.CloseNormalClosure || ce.Code == websocket.CloseGoingAway {
+			return ClosePeerEOF, nil
+		}
+		return ClosePeerEOF, err
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ClosePeerEOF, nil
+	}
+	return CloseReadError, err
+}
 
 // GetAddr 获取连接地址
 func (c *WSConn) GetAddr() string { return c.addr }
+
+// ProxyHeader websocket连接不使用PROXY协议，总是返回nil
+func (c *WSConn) ProxyHeader() *proxyproto.Header { return nil }
+
+// LocalAddr 本地地址
+func (c *WSConn) LocalAddr() net.Addr { return c.conn.LocalAddr() }
+
+// RemoteAddr 对端地址（信任代理转发时为真实客户端地址）
+func (c *WSConn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+// Listener 连接来自的监听地址
+func (c *WSConn) Listener() string { return c.listener.addr }
+
+// Transport 连接的传输类型
+func (c *WSConn) Transport() Transport { return TransportWS }
+
+// ConnectTime 连接建立的时间
+func (c *WSConn) ConnectTime() time.Time { return c.connectTime }
+
+// TLSState TLS连接的状态（wss），非TLS连接返回nil
+func (c *WSConn) TLSState() *tls.ConnectionState { return c.tlsState }
+
+// IdleTime 连接闲置时长
+func (c *WSConn) IdleTime() time.Duration {
+	return time.Since(time.Unix(c.activeTime.Get(), 0))
+}
@@ -0,0 +1,149 @@
+package limnet
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type wsCloseHandler struct {
+	DefaultEventHandler
+	conns  chan Conn
+	closes int32
+	closed chan struct{}
+}
+
+func (h *wsCloseHandler) OnConnect(c Conn) {
+	h.conns <- c
+}
+
+func (h *wsCloseHandler) OnClose(c Conn) {
+	if atomic.AddInt32(&h.closes, 1) == 1 {
+		close(h.closed)
+	}
+}
+
+func TestWSConn_CloseOnce(t *testing.T) {
+	h := &wsCloseHandler{conns: make(chan Conn, 1), closed: make(chan struct{})}
+	startServer(t, h, WithWSAddr("127.0.0.1:17151"))
+	conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:17151", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := recv(t, h.conns)
+
+	// 服务端多个goroutine同时关闭，客户端也同时断开
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = c.Close()
+		}()
+	}
+	_ = conn.Close()
+	wg.Wait()
+	recv(t, h.closed)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&h.closes); n != 1 {
+		t.Fatalf("expect OnClose once but got %d", n)
+	}
+}
+
+// concurrentWriteHandler 收到消息后在多个goroutine里用不同的写方法同时写
+type concurrentWriteHandler struct {
+	DefaultEventHandler
+	f    *os.File
+	errs chan error
+}
+
+const (
+	wsWriters       = 4
+	wsWritesPerPath = 20
+)
+
+func (h *concurrentWriteHandler) OnPacket(c Conn, data []byte) []byte {
+	for i := 0; i < wsWriters; i++ {
+		go func() {
+			var err error
+			for j := 0; j < wsWritesPerPath && err == nil; j++ {
+				if err = c.Write([]byte("w")); err == nil {
+					if err = c.Writev([]byte("v"), []byte("v")); err == nil {
+						err = c.SendFile(h.f, 0, 0)
+					}
+				}
+			}
+			h.errs <- err
+		}()
+	}
+	return []byte("reply") // eventloop里的回复和其他goroutine的写同时进行
+}
+
+func TestWSConn_ConcurrentWrite(t *testing.T) {
+	f, _ := tempFile(t, 64<<10)
+	h := &concurrentWriteHandler{f: f, errs: make(chan error, wsWriters)}
+	startServer(t, h, WithWSAddr("127.0.0.1:17155"))
+	conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:17155", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = conn.WriteMessage(websocket.BinaryMessage, []byte("go")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 1+wsWriters*wsWritesPerPath*3; i++ {
+		if _, _, err = conn.ReadMessage(); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+	for i := 0; i < wsWriters; i++ {
+		if err = recv(t, h.errs); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWSConn_TLSState(t *testing.T) {
+	// 借用httptest的自签名证书
+	ts := httptest.NewTLSServer(nil)
+	tlsConfig := ts.TLS.Clone()
+	ts.Close()
+
+	l, err := NewServer(&TestHandler{}, WithAddr("tcp://127.0.0.1:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &connectHandler{conns: make(chan Conn, 1)}
+	if _, err = l.AddListener("wss://127.0.0.1:17157", h, nil, WithListenerTLS(tlsConfig)); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Stop()
+
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}}}
+	ws, _, err := dialer.Dial("wss://127.0.0.1:17157", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	c := recv(t, h.conns)
+	if c.Listener() != "127.0.0.1:17157" || c.Transport() != TransportWS {
+		t.Fatalf("unexpected listener %s %v", c.Listener(), c.Transport())
+	}
+	state := c.TLSState()
+	if state == nil || !state.HandshakeComplete {
+		t.Fatalf("expect tls state but got %+v", state)
+	}
+	if state.NegotiatedProtocol != "http/1.1" {
+		t.Fatalf("expect http/1.1 but got %q", state.NegotiatedProtocol)
+	}
+}
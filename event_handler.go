@@ -1,5 +1,10 @@
 package limnet
 
+import (
+	"errors"
+	"fmt"
+)
+
 // EventHandler 事件处理者接口
 type EventHandler interface {
 	// 建立连接
@@ -10,6 +15,52 @@ type EventHandler interface {
 	OnClose(c Conn)
 }
 
+// ErrorHandler 可选接口，EventHandler实现此接口后可以收到运行中的错误通知
+// 包括UnPacket返回的错误、写失败、处理者中的panic（*eventloop.PanicError 含堆栈）、job返回的错误等
+// 需要关闭连接可以在OnError里调用 c.Close()
+type ErrorHandler interface {
+	// OnError 发生错误 与连接无关的错误（例如accept失败、job错误）c为nil
+	OnError(c Conn, err error)
+}
+
+// BufferPacketHandler 可选接口，EventHandler实现此接口后收到的包拷贝到引用计数的Buffer里，代替OnPacket调用
+// buf在回调返回后释放，需要在回调之后使用（例如交给其他goroutine）时先调用buf.Retain()，用完后buf.Release()
+type BufferPacketHandler interface {
+	// OnPacketBuffer 收到包 返回值同OnPacket
+	OnPacketBuffer(c Conn, buf *Buffer) (out []byte)
+}
+
+// WriteCompleteHandler 可选接口，EventHandler实现此接口后积压在输出buffer里的数据全部写出时收到通知
+// 可以用来做发送端的流控：写入太多时暂停生产，收到OnWriteComplete后继续（websocket连接的写入是同步的，不会调用）
+type WriteCompleteHandler interface {
+	// OnWriteComplete 输出buffer里的数据已全部交给内核，在eventloop里调用
+	OnWriteComplete(c Conn)
+}
+
+// ConnHandler 每个连接自己的事件处理者，由 ConnHandlerFactory 在连接建立时创建，只收到所属连接的事件
+// 可以同样实现 BufferPacketHandler、HalfCloseHandler、WriteCompleteHandler、ErrorHandler、ProtocolViolationHandler 等可选接口，EventHandler 也满足此接口
+type ConnHandler interface {
+	// OnPacket 收到包 返回值同 EventHandler.OnPacket
+	OnPacket(c Conn, data []byte) (out []byte)
+	// OnClose 连接关闭
+	OnClose(c Conn)
+}
+
+// ConnHandlerFactory 连接处理者工厂，设置后连接的OnPacket/OnClose交给NewHandler创建的处理者，
+// 方便每个会话维护自己的状态机（例如 握手 → 已认证 → 关闭中），tcp和websocket连接都适用。
+// NewHandler在OnConnect之前调用，返回nil的连接仍由 EventHandler 处理；
+// 连接的错误（ErrorHandler）和违反协议（ProtocolViolationHandler）通知也交给连接的处理者，
+// EventHandler 照常收到OnConnect以及RejectHandler、与连接无关的错误等监听器级别的通知
+type ConnHandlerFactory interface {
+	NewHandler(c Conn) ConnHandler
+}
+
+// ConnHandlerFactoryFunc 函数形式的 ConnHandlerFactory
+type ConnHandlerFactoryFunc func(c Conn) ConnHandler
+
+// NewHandler 创建连接的处理者
+func (f ConnHandlerFactoryFunc) NewHandler(c Conn) ConnHandler { return f(c) }
+
 // DefaultEventHandler 默认event处理者实现
 type DefaultEventHandler struct {
 }
@@ -29,9 +80,35 @@ func (d *DefaultEventHandler) OnClose(c Conn) {
 
 }
 
-// UnPacket 解包
+// UnPacket 解包 返回nil, nil或ErrIncompletePacket表示数据不足一个包，等待更多数据
+// 返回ErrProtocolViolation（可以用%w包装）将关闭连接，其他错误交给ErrorHandler
 type UnPacket func(c Conn) ([]byte, error)
 
+var (
+	// ErrIncompletePacket 数据不足一个完整的包，等待更多数据
+	ErrIncompletePacket = errors.New("数据包不完整")
+	// ErrProtocolViolation 数据违反协议，连接将被关闭
+	ErrProtocolViolation = errors.New("违反协议")
+	// ErrInboundBufferExceeded 未解包的数据超过 Options.MaxInboundBuffer （属于ErrProtocolViolation）
+	ErrInboundBufferExceeded = fmt.Errorf("%w：未解包的数据超过限制", ErrProtocolViolation)
+)
+
+// ProtocolViolationHandler 可选接口，EventHandler实现此接口后可以在连接因违反协议被关闭前写出一段数据（例如错误提示）
+type ProtocolViolationHandler interface {
+	// OnProtocolViolation 返回的数据会按封包协议封包后写出（尽力而为，不能立即写出的数据会被丢弃），然后关闭连接
+	OnProtocolViolation(c Conn, err error) (goodbye []byte)
+}
+
+// defaultUnPacket 默认解包 读取全部数据作为一个包
+func defaultUnPacket(c Conn) ([]byte, error) {
+	buf := c.Read()
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	c.ResetBuffer()
+	return buf, nil
+}
+
 // UnPacket 解包协议
 // type UnPacket interface {
 // 	// 解包
@@ -39,12 +116,20 @@ type UnPacket func(c Conn) ([]byte, error)
 // }
 
 // Packet 封包协议
+//
+// Deprecated: 拿不到要封包的数据，监听器的封包协议请使用 Packer
 type Packet interface {
 	// 封包
 	Packet(c Conn) []byte
 }
 
-// DefaultPacket 默认封包协议
+// Packer 监听器的封包协议，写出的数据先经过封包
+type Packer interface {
+	// 封包
+	Packet(c Conn, data []byte) []byte
+}
+
+// DefaultPacket 默认封包协议（原样输出）
 type DefaultPacket struct {
 }
 
@@ -0,0 +1,161 @@
+package limnet
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// EvictPolicy 缓冲的内存超过 Options.EvictMemory 时选择驱逐哪些连接
+type EvictPolicy int
+
+const (
+	// EvictLargestBacklog 优先驱逐输出积压最多的连接（默认）
+	EvictLargestBacklog EvictPolicy = iota
+	// EvictLongestStalled 优先驱逐写出停滞最久的连接
+	EvictLongestStalled
+)
+
+// evictInterval 检查缓冲内存的间隔
+const evictInterval = 100 * time.Millisecond
+
+// evictCandidate 有输出积压的连接（慢消费者）
+type evictCandidate struct {
+	conn    reasonCloser
+	id      int64
+	addr    string
+	backlog int           // 等待写出的字节数
+	stalled time.Duration // 写出停滞的时长
+	memory  int           // 占用的buffer内存
+}
+
+// reasonCloser 可以记录关闭原因的连接
+type reasonCloser interface {
+	Conn
+	closeWithReason(reason CloseReason) error
+}
+
+// BufferedBytes 所有连接的输入输出buffer当前占用的内存
+func (l *LIMNet) BufferedBytes() int64 {
+	return l.limiter.bufferedBytes()
+}
+
+// bufferResized 连接占用的buffer内存变化了delta字节
+func (l *LIMNet) bufferResized(delta int) {
+	l.limiter.bufferResized(delta)
+}
+
+// runEviction 定时检查缓冲的内存，超过 Options.EvictMemory 时驱逐慢消费者，直到stop被关闭
+func (l *LIMNet) runEviction(stop <-chan struct{}) {
+	ticker := time.NewTicker(evictInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			l.evict()
+		}
+	}
+}
+
+// evict 按驱逐策略从最差的连接开始驱逐，直到预计的缓冲内存回到上限以下
+func (l *LIMNet) evict() {
+	over := l.BufferedBytes() - l.opts.EvictMemory
+	if over <= 0 {
+		return
+	}
+	candidates := l.evictCandidates(evictInterval)
+	reason := CloseEvictedBacklog
+	if l.opts.EvictPolicy == EvictLongestStalled {
+		reason = CloseEvictedStalled
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].stalled > candidates[j].stalled })
+	} else {
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].backlog > candidates[j].backlog })
+	}
+	for _, cand := range candidates {
+		if over <= 0 {
+			break
+		}
+		over -= int64(cand.memory)
+		l.Warn("缓冲的内存超过驱逐上限，驱逐慢消费者！", zap.Int64("id", cand.id), zap.String("addr", cand.addr), zap.String("reason", string(reason)),
+			zap.Int("backlog", cand.backlog), zap.Duration("stalled", cand.stalled), zap.Int("memory", cand.memory))
+		l.metrics.ConnEvicted(cand.conn.Transport(), reason)
+		_ = cand.conn.closeWithReason(reason)
+	}
+}
+
+// evictCandidates 收集有输出积压的连接 tcp连接的buffer只能在所属eventloop里读取，所以需要投递到各个eventloop里收集
+func (l *LIMNet) evictCandidates(timeout time.Duration) []evictCandidate {
+	var (
+		mu         sync.Mutex
+		candidates []evictCandidate
+		wg         sync.WaitGroup
+	)
+	add := func(cand evictCandidate) {
+		mu.Lock()
+		candidates = append(candidates, cand)
+		mu.Unlock()
+	}
+	for i := range l.connectLoops {
+		loop := l.connectLoops[i]
+		wg.Add(1)
+		_ = loop.Trigger(func() error {
+			defer wg.Done()
+			now := time.Now()
+			for c := range l.backlogged[loop] { // 只遍历此eventloop里输出有积压的连接
+				if !c.connected.Get() || c.outboundBuffer.IsEmpty() {
+					continue
+				}
+				add(evictCandidate{
+					conn:    c,
+					id:      c.id,
+					addr:    c.addr,
+					backlog: c.outboundBuffer.Length(),
+					stalled: now.Sub(c.writeStalledAt),
+					memory:  c.bufferMemory(),
+				})
+			}
+			return nil
+		})
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		l.Warn("收集慢消费者连接超时，只驱逐部分连接")
+	}
+
+	now := time.Now().UnixNano()
+	l.conns.Range(func(key, value interface{}) bool {
+		c, ok := value.(*WSConn)
+		if !ok || !c.connected.Get() {
+			return true
+		}
+		since := c.writingSince.Get()
+		if since == 0 {
+			return true
+		}
+		add(evictCandidate{
+			conn:    c,
+			id:      c.id,
+			addr:    c.addr,
+			backlog: int(c.writingBytes.Get()),
+			stalled: time.Duration(now - since),
+			memory:  int(c.memory.Get()),
+		})
+		return true
+	})
+
+	mu.Lock()
+	result := make([]evictCandidate, len(candidates))
+	copy(result, candidates)
+	mu.Unlock()
+	return result
+}
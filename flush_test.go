@@ -0,0 +1,125 @@
+package limnet
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+type flushTestHandler struct {
+	DefaultEventHandler
+	cbErrs chan error
+	closed chan CloseReason
+}
+
+func newFlushTestHandler() *flushTestHandler {
+	return &flushTestHandler{cbErrs: make(chan error, 10), closed: make(chan CloseReason, 10)}
+}
+
+func (h *flushTestHandler) OnPacket(c Conn, data []byte) []byte {
+	cb := func(err error) { h.cbErrs <- err }
+	switch string(data) {
+	case "flush": // 积压的数据写完后关闭
+		_ = c.Write(bigPayload())
+		_ = c.CloseAfterFlush(0)
+	case "stall": // 对端不读，超时后关闭
+		_ = c.Write(bigPayload())
+		_ = c.CloseAfterFlush(100 * time.Millisecond)
+	case "cb":
+		_ = c.WriteWithCallback([]byte("small\n"), cb)
+		_ = c.WriteWithCallback(bigPayload(), cb)
+	case "cbclose": // 没写完就关闭连接
+		_ = c.WriteWithCallback(bigPayload(), cb)
+		_ = c.Close()
+	}
+	return nil
+}
+
+func (h *flushTestHandler) OnClose(c Conn) {
+	h.closed <- c.CloseReason()
+}
+
+func TestCloseAfterFlush(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := newFlushTestHandler()
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(lineUnPacket))
+		conn := dial(t, addr)
+		if _, err := conn.Write([]byte("flush\n")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(200 * time.Millisecond) // 不读取，让数据积压
+		select {
+		case reason := <-h.closed:
+			t.Fatalf("expect conn kept open until flushed but closed: %s", reason)
+		default:
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		got, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, bigPayload()) {
+			t.Fatalf("expect all backlog flushed but got %d bytes", len(got))
+		}
+		expectReason(t, h.closed, CloseLocal)
+	})
+}
+
+func TestCloseAfterFlush_Timeout(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := newFlushTestHandler()
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(lineUnPacket))
+		conn := dial(t, addr)
+		if _, err := conn.Write([]byte("stall\n")); err != nil {
+			t.Fatal(err)
+		}
+		expectReason(t, h.closed, CloseFlushTimeout)
+	})
+}
+
+func TestWriteWithCallback(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := newFlushTestHandler()
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(lineUnPacket))
+		conn := dial(t, addr)
+		if _, err := conn.Write([]byte("cb\n")); err != nil {
+			t.Fatal(err)
+		}
+		// 小的数据直接写完
+		if err := recv(t, h.cbErrs); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-h.cbErrs:
+			t.Fatalf("expect big write pending but callback got %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		r := bufio.NewReader(conn)
+		expectLines(t, r, "small")
+		if _, err := io.ReadFull(r, make([]byte, len(bigPayload()))); err != nil {
+			t.Fatal(err)
+		}
+		if err := recv(t, h.cbErrs); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestWriteWithCallback_Close(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := newFlushTestHandler()
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(lineUnPacket))
+		conn := dial(t, addr)
+		if _, err := conn.Write([]byte("cbclose\n")); err != nil {
+			t.Fatal(err)
+		}
+		// 没写完的数据随连接关闭丢弃，回调收到错误
+		if err := recv(t, h.cbErrs); err != ErrConnectionClosed {
+			t.Fatalf("expect %v but got %v", ErrConnectionClosed, err)
+		}
+		expectReason(t, h.closed, CloseLocal)
+	})
+}
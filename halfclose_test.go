@@ -0,0 +1,154 @@
+package limnet
+
+import (
+	"bufio"
+	"io"
+	"testing"
+	"time"
+)
+
+type halfCloseTestHandler struct {
+	DefaultEventHandler
+	packets chan string
+	closed  chan CloseReason
+}
+
+func newHalfCloseTestHandler() *halfCloseTestHandler {
+	return &halfCloseTestHandler{packets: make(chan string, 10), closed: make(chan CloseReason, 10)}
+}
+
+func (h *halfCloseTestHandler) OnPacket(c Conn, data []byte) []byte {
+	h.packets <- string(data)
+	switch string(data) {
+	case "shut": // 写出回复后关闭写方向
+		_ = c.Write([]byte("bye\n"))
+		_ = c.CloseWrite()
+		return nil
+	case "close":
+		_ = c.Close()
+		return nil
+	}
+	return append(data, '\n')
+}
+
+func (h *halfCloseTestHandler) OnClose(c Conn) {
+	h.closed <- c.CloseReason()
+}
+
+// keepOpenHandler 实现了HalfCloseHandler，对端半关闭后写出最后的回复再关闭写方向
+type keepOpenHandler struct {
+	*halfCloseTestHandler
+	halfClosed chan struct{}
+}
+
+func (h *keepOpenHandler) OnPeerHalfClose(c Conn) {
+	close(h.halfClosed)
+	_ = c.Write([]byte("late\n"))
+	_ = c.CloseWrite()
+}
+
+func expectLines(t *testing.T, r *bufio.Reader, lines ...string) {
+	t.Helper()
+	for _, want := range lines {
+		if line := readLine(t, r); line != want {
+			t.Fatalf("expect %q but got %q", want, line)
+		}
+	}
+}
+
+func expectEOF(t *testing.T, r *bufio.Reader) {
+	t.Helper()
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Fatalf("expect EOF but got %v", err)
+	}
+}
+
+func expectReason(t *testing.T, closed chan CloseReason, want CloseReason) {
+	t.Helper()
+	if reason := recv(t, closed); reason != want {
+		t.Fatalf("expect %s but got %s", want, reason)
+	}
+}
+
+func TestPeerHalfClose(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := newHalfCloseTestHandler()
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(lineUnPacket))
+		conn := dial(t, addr)
+		if _, err := conn.Write([]byte("a\nb\n")); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.CloseWrite()
+		// 没有实现HalfCloseHandler，写完已有的回复后关闭连接
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		r := bufio.NewReader(conn)
+		expectLines(t, r, "a", "b")
+		expectEOF(t, r)
+		expectReason(t, h.closed, ClosePeerEOF)
+	})
+}
+
+func TestPeerHalfClose_KeepOpen(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := &keepOpenHandler{halfCloseTestHandler: newHalfCloseTestHandler(), halfClosed: make(chan struct{})}
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(lineUnPacket))
+		conn := dial(t, addr)
+		if _, err := conn.Write([]byte("a\n")); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.CloseWrite()
+		recv(t, h.halfClosed)
+		// 连接保持打开，OnPeerHalfClose里还能写出回复
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		r := bufio.NewReader(conn)
+		expectLines(t, r, "a", "late")
+		expectEOF(t, r)
+		// 两个方向都关闭后连接关闭
+		expectReason(t, h.closed, ClosePeerEOF)
+	})
+}
+
+func TestCloseWrite(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := newHalfCloseTestHandler()
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(lineUnPacket))
+		conn := dial(t, addr)
+		if _, err := conn.Write([]byte("shut\n")); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		r := bufio.NewReader(conn)
+		// 关闭写方向前的数据先写出
+		expectLines(t, r, "bye")
+		expectEOF(t, r)
+		// 服务端只关闭了写方向，还能收到数据
+		if _, err := conn.Write([]byte("more\n")); err != nil {
+			t.Fatal(err)
+		}
+		recv(t, h.packets)
+		if p := recv(t, h.packets); p != "more" {
+			t.Fatalf("expect more but got %q", p)
+		}
+		select {
+		case reason := <-h.closed:
+			t.Fatalf("expect conn kept open but closed: %s", reason)
+		default:
+		}
+		_ = conn.CloseWrite()
+		expectReason(t, h.closed, ClosePeerEOF)
+	})
+}
+
+func TestCloseReason_Local(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := newHalfCloseTestHandler()
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(lineUnPacket))
+		conn := dial(t, addr)
+		if _, err := conn.Write([]byte("close\n")); err != nil {
+			t.Fatal(err)
+		}
+		expectReason(t, h.closed, CloseLocal)
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		expectEOF(t, bufio.NewReader(conn))
+	})
+}
@@ -0,0 +1,84 @@
+package limnet
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tangtaoit/limnet/pkg/limpoller"
+)
+
+// eachBackend 在每个可用的IO模型下运行测试，io_uring不可用时只测epoll/kqueue
+func eachBackend(t *testing.T, f func(t *testing.T, backend PollerBackend)) {
+	t.Run("epoll", func(t *testing.T) { f(t, PollerEpoll) })
+	t.Run("iouring", func(t *testing.T) {
+		if !limpoller.IOURingAvailable() {
+			t.Skip("io_uring不可用")
+		}
+		f(t, PollerIOURing)
+	})
+}
+
+// startServer 启动监听随机端口的服务，测试结束时停止，返回服务和tcp地址
+func startServer(t *testing.T, h EventHandler, optFuncs ...Option) (*LIMNet, string) {
+	t.Helper()
+	optFuncs = append([]Option{WithAddr("tcp://127.0.0.1:0")}, optFuncs...)
+	l, err := NewServer(h, optFuncs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Stop() })
+	return l, l.tcp.realAddr
+}
+
+// dial 连接服务，测试结束时关闭
+func dial(t *testing.T, addr string) *net.TCPConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn.(*net.TCPConn)
+}
+
+// readLine 读取一行（不含换行）
+func readLine(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read line: %v", err)
+	}
+	return line[:len(line)-1]
+}
+
+// lineUnPacket 按换行拆包，包不含换行
+func lineUnPacket(c Conn) ([]byte, error) {
+	head, tail := c.Peek(0)
+	data := make([]byte, 0, len(head)+len(tail))
+	data = append(append(data, head...), tail...)
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, ErrIncompletePacket
+	}
+	c.Discard(i + 1)
+	return data[:i], nil
+}
+
+// recv 等待ch收到值，超时则失败
+func recv[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+	var zero T
+	return zero
+}
@@ -0,0 +1,158 @@
+package limnet
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tangtaoit/limnet/pkg/limpoller"
+)
+
+type ringTestHandler struct {
+	DefaultEventHandler
+	packets chan string
+	closed  chan CloseReason
+}
+
+func newRingTestHandler() *ringTestHandler {
+	return &ringTestHandler{packets: make(chan string, 100), closed: make(chan CloseReason, 100)}
+}
+
+func (h *ringTestHandler) OnPacket(c Conn, data []byte) []byte {
+	h.packets <- string(data)
+	if string(data) == "big" {
+		return bigPayload()
+	}
+	return append(data, '\n')
+}
+
+func (h *ringTestHandler) OnClose(c Conn) {
+	h.closed <- c.CloseReason()
+}
+
+// bigPayload 远大于socket缓冲的数据，一次send写不完
+func bigPayload() []byte {
+	out := make([]byte, 16<<20)
+	for i := range out {
+		out[i] = byte(i % 251)
+	}
+	return out
+}
+
+func startRingServer(t *testing.T, h EventHandler, optFuncs ...Option) (*LIMNet, string) {
+	t.Helper()
+	if !limpoller.IOURingAvailable() {
+		t.Skip("io_uring不可用")
+	}
+	optFuncs = append([]Option{WithPoller(PollerIOURing), WithUnPacket(lineUnPacket)}, optFuncs...)
+	l, addr := startServer(t, h, optFuncs...)
+	if !l.listenerLoop.IsRing() {
+		t.Fatal("expect io_uring loop")
+	}
+	return l, addr
+}
+
+func TestIOURing_Accept(t *testing.T) {
+	_, addr := startRingServer(t, newRingTestHandler())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn := dial(t, addr)
+			msg := fmt.Sprintf("hello %d", i)
+			if _, err := conn.Write([]byte(msg + "\n")); err != nil {
+				t.Error(err)
+				return
+			}
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil || line != msg+"\n" {
+				t.Errorf("expect %q but got %q, %v", msg, line, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestIOURing_PartialSend(t *testing.T) {
+	_, addr := startRingServer(t, newRingTestHandler())
+	conn := dial(t, addr)
+	if _, err := conn.Write([]byte("big\nping\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond) // 不读取，让send只能写出一部分
+
+	r := bufio.NewReader(conn)
+	got := make([]byte, 16<<20)
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, bigPayload()) {
+		t.Fatal("payload mismatch")
+	}
+	if line := readLine(t, r); line != "ping" {
+		t.Fatalf("expect ping after payload but got %q", line)
+	}
+}
+
+func TestIOURing_Throttle(t *testing.T) {
+	h := newRingTestHandler()
+	_, addr := startRingServer(t, h, WithPacketRate(2, 1, RateActionThrottle))
+	conn := dial(t, addr)
+	start := time.Now()
+	if _, err := conn.Write([]byte("1\n2\n")); err != nil {
+		t.Fatal(err)
+	}
+	if p := recv(t, h.packets); p != "1" {
+		t.Fatalf("expect 1 but got %q", p)
+	}
+	if p := recv(t, h.packets); p != "2" {
+		t.Fatalf("expect 2 but got %q", p)
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Fatalf("expect throttled but second packet after %v", d)
+	}
+	// 限流期间取消的recv在恢复后重新提交
+	if _, err := conn.Write([]byte("3\n")); err != nil {
+		t.Fatal(err)
+	}
+	if p := recv(t, h.packets); p != "3" {
+		t.Fatalf("expect 3 but got %q", p)
+	}
+}
+
+func TestIOURing_Close(t *testing.T) {
+	if !limpoller.IOURingAvailable() {
+		t.Skip("io_uring不可用")
+	}
+	before := openFds()
+	h := newRingTestHandler()
+	l, err := NewServer(h, WithAddr("tcp://127.0.0.1:0"), WithPoller(PollerIOURing), WithUnPacket(lineUnPacket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Start(); err != nil {
+		t.Fatal(err)
+	}
+	conn := dial(t, l.tcp.realAddr)
+	if _, err = conn.Write([]byte("hi\n")); err != nil {
+		t.Fatal(err)
+	}
+	recv(t, h.packets)
+	if err = l.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if reason := recv(t, h.closed); reason != CloseServerStop {
+		t.Fatalf("expect %s but got %s", CloseServerStop, reason)
+	}
+	_ = conn.Close()
+	// ring的fd、eventfd和监听fd都已释放
+	if after := openFds(); after != before {
+		t.Fatalf("expect %d fds but got %d", before, after)
+	}
+}
@@ -0,0 +1,175 @@
+package limnet
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tangtaoit/limnet/pkg/acl"
+	"github.com/tangtaoit/limnet/pkg/limutil"
+)
+
+// RejectReason 连接被拒绝的原因
+type RejectReason string
+
+const (
+	// RejectMaxConns 超过最大连接数
+	RejectMaxConns RejectReason = "max_conns"
+	// RejectMaxConnsPerIP 超过单个IP的最大连接数
+	RejectMaxConnsPerIP RejectReason = "max_conns_per_ip"
+	// RejectAcceptRate 超过接受连接的速率
+	RejectAcceptRate RejectReason = "accept_rate"
+	// RejectACL 被ip访问控制拒绝
+	RejectACL RejectReason = "acl"
+	// RejectMemoryBudget 连接缓冲的内存超过 Options.MaxBufferMemory
+	RejectMemoryBudget RejectReason = "memory_budget"
+)
+
+// ErrMemoryBudgetExceeded 所有连接缓冲的内存超过 Options.MaxBufferMemory ，继续缓冲数据的连接将被关闭
+var ErrMemoryBudgetExceeded = errors.New("连接缓冲的内存超过限制")
+
+// RejectHandler 可选接口，EventHandler实现此接口后可以收到连接被拒绝的通知
+type RejectHandler interface {
+	// OnReject 连接在建立前被拒绝（连接已被关闭）
+	OnReject(addr string, reason RejectReason)
+}
+
+// RateAction 超过速率限制后的处理方式
+type RateAction int
+
+const (
+	// RateActionThrottle 暂停读取，直到有可用的令牌
+	RateActionThrottle RateAction = iota
+	// RateActionClose 直接关闭连接
+	RateActionClose
+)
+
+// connLimiter 接受连接时的连接数与速率限制
+type connLimiter struct {
+	opts         *Options
+	total        int64 // 当前连接总数
+	buffered     int64 // 所有连接从池里取的ringbuffer占用的内存
+	lock         sync.Mutex
+	perIP        map[string]int // 每个IP的连接数
+	acceptBucket *limutil.TokenBucket
+}
+
+func newConnLimiter(opts *Options) *connLimiter {
+	c := &connLimiter{
+		opts:  opts,
+		perIP: map[string]int{},
+	}
+	if opts.AcceptRate > 0 {
+		c.acceptBucket = limutil.NewTokenBucket(opts.AcceptRate, opts.AcceptBurst)
+	}
+	return c
+}
+
+// acquire 检查是否允许建立新连接，允许则占用一个名额，之后必须调用release释放
+// 检查和占用在同一把锁里完成，并发的websocket升级也不会超过限制；最后才消耗接受连接的令牌，被其他原因拒绝的连接不占用速率
+// addr为空时不检查单个IP的连接数（等待PROXY协议头的连接还不知道真实地址），之后用acquireIP补上
+func (c *connLimiter) acquire(addr string) (RejectReason, bool) {
+	if c.overBudget() {
+		return RejectMemoryBudget, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.opts.MaxConns > 0 && atomic.LoadInt64(&c.total) >= int64(c.opts.MaxConns) {
+		return RejectMaxConns, false
+	}
+	ip := ""
+	if c.opts.MaxConnsPerIP > 0 && addr != "" {
+		ip = hostOf(addr)
+		if c.perIP[ip] >= c.opts.MaxConnsPerIP {
+			return RejectMaxConnsPerIP, false
+		}
+	}
+	if c.acceptBucket != nil && !c.acceptBucket.Allow() {
+		return RejectAcceptRate, false
+	}
+	if ip != "" {
+		c.perIP[ip]++
+	}
+	atomic.AddInt64(&c.total, 1)
+	return "", true
+}
+
+// acquireIP 为acquire时没有指定地址的连接占用单个IP的名额，release时传同一个地址释放
+func (c *connLimiter) acquireIP(addr string) (RejectReason, bool) {
+	if c.opts.MaxConnsPerIP <= 0 {
+		return "", true
+	}
+	ip := hostOf(addr)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.perIP[ip] >= c.opts.MaxConnsPerIP {
+		return RejectMaxConnsPerIP, false
+	}
+	c.perIP[ip]++
+	return "", true
+}
+
+// release 释放acquire（和acquireIP）占用的名额，addr为空只释放连接总数
+func (c *connLimiter) release(addr string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	atomic.AddInt64(&c.total, -1)
+	if c.opts.MaxConnsPerIP > 0 && addr != "" {
+		ip := hostOf(addr)
+		if c.perIP[ip] <= 1 {
+			delete(c.perIP, ip)
+		} else {
+			c.perIP[ip]--
+		}
+	}
+}
+
+// bufferResized 连接占用的ringbuffer内存变化了delta字节（取出、扩容或放回池里）
+func (c *connLimiter) bufferResized(delta int) {
+	if delta != 0 {
+		atomic.AddInt64(&c.buffered, int64(delta))
+	}
+}
+
+// bufferedBytes 所有连接占用的ringbuffer内存
+func (c *connLimiter) bufferedBytes() int64 {
+	return atomic.LoadInt64(&c.buffered)
+}
+
+// overBudget 所有连接缓冲的内存是否超过了 Options.MaxBufferMemory
+func (c *connLimiter) overBudget() bool {
+	return c.opts.MaxBufferMemory > 0 && atomic.LoadInt64(&c.buffered) > c.opts.MaxBufferMemory
+}
+
+// newPacketLimiter 根据配置创建单个连接的包速率限制器，未配置返回nil
+func newPacketLimiter(opts *Options) *limutil.TokenBucket {
+	if opts.PacketRate <= 0 {
+		return nil
+	}
+	return limutil.NewTokenBucket(opts.PacketRate, opts.PacketBurst)
+}
+
+// SetACL 运行时更新ip访问控制，对之后接受的连接生效，传nil则允许所有ip
+func (l *LIMNet) SetACL(a *acl.ACL) {
+	l.acl.Store(aclHolder{a})
+}
+
+// GetACL 获取当前的ip访问控制
+func (l *LIMNet) GetACL() *acl.ACL {
+	return l.acl.Load().(aclHolder).acl
+}
+
+// aclHolder atomic.Value不能存nil，所以包一层
+type aclHolder struct {
+	acl *acl.ACL
+}
+
+// hostOf 获取地址中的host部分
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,56 @@
+package limnet
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConnLimiter_MaxConns(t *testing.T) {
+	opts := NewOption()
+	opts.MaxConns = 10
+	c := newConnLimiter(opts)
+
+	var ok int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, allowed := c.acquire(fmt.Sprintf("10.0.0.%d:1000", i)); allowed {
+				atomic.AddInt32(&ok, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if ok != 10 {
+		t.Fatalf("expect 10 conns but got %d", ok)
+	}
+}
+
+func TestConnLimiter_AcceptRateLast(t *testing.T) {
+	opts := NewOption()
+	opts.MaxConnsPerIP = 1
+	opts.AcceptRate = 0.001
+	opts.AcceptBurst = 2
+	c := newConnLimiter(opts)
+
+	if _, ok := c.acquire("1.1.1.1:1000"); !ok {
+		t.Fatal("expect allowed")
+	}
+	if reason, _ := c.acquire("1.1.1.1:1001"); reason != RejectMaxConnsPerIP {
+		t.Fatalf("expect %s but got %s", RejectMaxConnsPerIP, reason)
+	}
+	// 上一个连接被拒绝时没有消耗令牌
+	if _, ok := c.acquire("2.2.2.2:1000"); !ok {
+		t.Fatal("expect allowed")
+	}
+	if reason, _ := c.acquire("3.3.3.3:1000"); reason != RejectAcceptRate {
+		t.Fatalf("expect %s but got %s", RejectAcceptRate, reason)
+	}
+	c.release("1.1.1.1:1000")
+	if len(c.perIP) != 1 || c.total != 1 {
+		t.Fatalf("expect 1 conn but got perIP %v total %d", c.perIP, c.total)
+	}
+}
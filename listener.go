@@ -0,0 +1,318 @@
+package limnet
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tangtaoit/limnet/pkg/acl"
+	"go.uber.org/zap"
+)
+
+// Codec 监听器的编解码协议
+type Codec struct {
+	UnPacket UnPacket // 解包协议，为nil则使用默认的解包（读取全部数据）
+	Packet   Packer   // 封包协议，为nil则不封包
+}
+
+// ListenerOptions 监听器配置
+type ListenerOptions struct {
+	IdleTime  time.Duration // 连接闲置时间，为0则使用 Options.ConnIdleTime，小于0则不检查
+	TLSConfig *tls.Config   // TLS配置，只支持websocket监听器（wss），tcp监听器不支持TLS，设置了会返回 ErrTCPTLSUnsupported
+	ACL       *acl.ACL      // 监听器的ip访问控制，为nil则使用全局的访问控制
+	// ConnHandlerFactory 监听器的连接处理者工厂，为nil则使用 Options.ConnHandlerFactory
+	ConnHandlerFactory ConnHandlerFactory
+}
+
+// ErrTCPTLSUnsupported tcp监听器不支持TLS（eventloop直接读写socket，不在处理范围内）
+var ErrTCPTLSUnsupported = errors.New("tcp监听器不支持TLS")
+
+// ErrListenerAfterStart 服务启动（或停止）之后不能再添加监听器
+var ErrListenerAfterStart = errors.New("服务启动之后不能再添加监听器")
+
+// ListenerOption 监听器参数项
+type ListenerOption func(*ListenerOptions) error
+
+// WithListenerIdleTime 设置监听器的连接闲置时间
+func WithListenerIdleTime(idleTime time.Duration) ListenerOption {
+	return func(opts *ListenerOptions) error {
+		opts.IdleTime = idleTime
+		return nil
+	}
+}
+
+// WithListenerTLS 设置监听器的TLS配置（只支持websocket监听器，tcp连接需要TLS时请在前面使用TLS终结代理）
+func WithListenerTLS(tlsConfig *tls.Config) ListenerOption {
+	return func(opts *ListenerOptions) error {
+		opts.TLSConfig = tlsConfig
+		return nil
+	}
+}
+
+// WithListenerACL 设置监听器的ip访问控制 allow和deny为CIDR列表
+func WithListenerACL(allow []string, deny []string) ListenerOption {
+	return func(opts *ListenerOptions) error {
+		a, err := acl.New(allow, deny)
+		if err != nil {
+			return err
+		}
+		opts.ACL = a
+		return nil
+	}
+}
+
+// WithListenerConnHandlerFactory 设置监听器的连接处理者工厂
+func WithListenerConnHandlerFactory(factory ConnHandlerFactory) ListenerOption {
+	return func(opts *ListenerOptions) error {
+		opts.ConnHandlerFactory = factory
+		return nil
+	}
+}
+
+// Listener 监听器 每个监听器有自己的事件处理者和编解码协议，共享LIMNet的eventloop
+type Listener struct {
+	lnet         *LIMNet
+	addr         string // 监听地址（不含协议头）
+	transport    Transport
+	eventHandler EventHandler
+	unPacket     UnPacket
+	packet       Packer
+	opts         *ListenerOptions
+	tcp          *TCPServer
+	ws           *WSServer
+}
+
+// AddListener 添加一个监听器（需要在Start/Run之前调用，之后调用返回 ErrListenerAfterStart）
+// addr 例如 tcp://0.0.0.0:7000、ws://0.0.0.0:8000、wss://0.0.0.0:8443（wss需要设置TLS配置，tcp不支持TLS），没有协议头按tcp处理
+func (l *LIMNet) AddListener(addr string, handler EventHandler, codec *Codec, optFuncs ...ListenerOption) (*Listener, error) {
+	l.lifecycle.Lock()
+	defer l.lifecycle.Unlock()
+	if atomic.LoadInt32(&l.state) != stateInit {
+		return nil, ErrListenerAfterStart
+	}
+	ln, err := l.newListener(addr, handler, codec, optFuncs...)
+	if err != nil {
+		return nil, err
+	}
+	switch ln.transport {
+	case TransportTCP:
+		if ln.opts.TLSConfig != nil {
+			return nil, ErrTCPTLSUnsupported
+		}
+		if _, err = parseAddr(ln.addr); err != nil {
+			return nil, err
+		}
+		if ln.tcp, err = newTCPServer(ln); err != nil {
+			return nil, err
+		}
+	case TransportWS:
+		ln.ws = newWSServer(ln)
+	}
+	l.listeners = append(l.listeners, ln)
+	l.Debug("添加监听器", zap.String("addr", addr))
+	return ln, nil
+}
+
+func (l *LIMNet) newListener(addr string, handler EventHandler, codec *Codec, optFuncs ...ListenerOption) (*Listener, error) {
+	opts := &ListenerOptions{}
+	for _, opt := range optFuncs {
+		if opt != nil {
+			if err := opt(opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if handler == nil {
+		if opts.ConnHandlerFactory == nil && l.opts.ConnHandlerFactory == nil {
+			return nil, errors.New("监听器的事件处理者不能为空")
+		}
+		handler = &DefaultEventHandler{} // 连接的事件都交给工厂创建的处理者
+	}
+	ln := &Listener{
+		lnet:         l,
+		addr:         addr,
+		transport:    TransportTCP,
+		eventHandler: handler,
+		unPacket:     l.opts.unPacket,
+		opts:         opts,
+	}
+	if codec != nil {
+		if codec.UnPacket != nil {
+			ln.unPacket = codec.UnPacket
+		}
+		ln.packet = codec.Packet
+	}
+	if strings.Contains(addr, "://") {
+		pair := strings.SplitN(addr, "://", 2)
+		switch strings.ToLower(pair[0]) {
+		case "tcp":
+			ln.addr = pair[1]
+		case "ws":
+			ln.transport = TransportWS
+			ln.addr = pair[1]
+		case "wss":
+			if opts.TLSConfig == nil {
+				return nil, errors.New("wss监听器必须设置TLS配置")
+			}
+			ln.transport = TransportWS
+			ln.addr = pair[1]
+		default:
+			return nil, errors.New("不支持的监听协议：" + pair[0])
+		}
+	}
+	if _, _, err := splitAddr(ln.addr); err != nil {
+		return nil, err
+	}
+	return ln, nil
+}
+
+// Addr 监听地址
+func (ln *Listener) Addr() string { return ln.addr }
+
+// Transport 监听器的传输类型
+func (ln *Listener) Transport() Transport { return ln.transport }
+
+// EventHandler 监听器的事件处理者
+func (ln *Listener) EventHandler() EventHandler { return ln.eventHandler }
+
+// defaultListener 默认的tcp或websocket监听器（给没有监听器参数的构造函数使用），没有开启时返回一个使用全局配置、不会监听的监听器
+func (l *LIMNet) defaultListener(transport Transport) *Listener {
+	if transport == TransportTCP && l.tcp != nil {
+		return l.tcp.listener
+	}
+	if transport == TransportWS && l.ws != nil {
+		return l.ws.listener
+	}
+	addr := l.opts.WSAddr
+	if transport == TransportTCP {
+		addr = l.opts.Addr
+		if i := strings.Index(addr, "://"); i >= 0 {
+			addr = addr[i+len("://"):]
+		}
+	}
+	return &Listener{
+		lnet:         l,
+		addr:         addr,
+		transport:    transport,
+		eventHandler: l.eventHandler,
+		unPacket:     l.opts.unPacket,
+		opts:         &ListenerOptions{},
+	}
+}
+
+// idleTime 连接闲置时间
+func (ln *Listener) idleTime() time.Duration {
+	if ln.opts.IdleTime != 0 {
+		return ln.opts.IdleTime
+	}
+	return ln.lnet.opts.ConnIdleTime
+}
+
+// acl 监听器的ip访问控制
+func (ln *Listener) acl() *acl.ACL {
+	if ln.opts.ACL != nil {
+		return ln.opts.ACL
+	}
+	return ln.lnet.GetACL()
+}
+
+// pack 写出数据前按监听器的封包协议封包
+func (ln *Listener) pack(c Conn, data []byte) []byte {
+	if ln.packet == nil {
+		return data
+	}
+	return ln.packet.Packet(c, data)
+}
+
+// packv 写出多个buffer前封包，有封包协议时合并成一个包再封包
+func (ln *Listener) packv(c Conn, bufs [][]byte) [][]byte {
+	if ln.packet == nil {
+		return bufs
+	}
+	return [][]byte{ln.packet.Packet(c, bytes.Join(bufs, nil))}
+}
+
+// onConnect 用连接处理者工厂创建连接的处理者，再触发OnConnect
+func (ln *Listener) onConnect(c handlerConn) {
+	factory := ln.opts.ConnHandlerFactory
+	if factory == nil {
+		factory = ln.lnet.opts.ConnHandlerFactory
+	}
+	if factory != nil {
+		c.setConnHandler(factory.NewHandler(c))
+	}
+	ln.eventHandler.OnConnect(c)
+}
+
+// handlerOf 连接的处理者，没有工厂创建的处理者时为监听器的EventHandler
+func (ln *Listener) handlerOf(c Conn) ConnHandler {
+	if hc, ok := c.(handlerConn); ok {
+		if h := hc.connHandler(); h != nil {
+			return h
+		}
+	}
+	return ln.eventHandler
+}
+
+// onPacket 触发OnPacket或OnPacketBuffer，buf不为nil时需要在写出out之后Release
+func (ln *Listener) onPacket(c Conn, data []byte) (out []byte, buf *Buffer) {
+	handler := ln.handlerOf(c)
+	h, ok := handler.(BufferPacketHandler)
+	if !ok {
+		return handler.OnPacket(c, data), nil
+	}
+	buf = CopyBuffer(data)
+	return h.OnPacketBuffer(c, buf), buf
+}
+
+// reject 通知连接被拒绝
+func (ln *Listener) reject(addr string, reason RejectReason) {
+	ln.lnet.Debug("拒绝连接", zap.String("listener", ln.addr), zap.String("addr", addr), zap.String("reason", string(reason)))
+	ln.lnet.metrics.ConnRejected(ln.transport, reason)
+	if h, ok := ln.eventHandler.(RejectHandler); ok {
+		h.OnReject(addr, reason)
+	}
+}
+
+// onError 通知发生错误 连接的错误交给连接的处理者，与连接无关的错误（c为nil）交给监听器的EventHandler
+func (ln *Listener) onError(c Conn, err error) {
+	var handler interface{} = ln.eventHandler
+	if c != nil {
+		handler = ln.handlerOf(c)
+	}
+	if h, ok := handler.(ErrorHandler); ok {
+		h.OnError(c, err)
+	}
+}
+
+// decodeError 处理UnPacket返回的错误 返回true表示违反协议需要关闭连接
+func (ln *Listener) decodeError(c Conn, err error) (violation bool) {
+	switch {
+	case errors.Is(err, ErrIncompletePacket):
+		return false
+	case errors.Is(err, ErrProtocolViolation):
+		return true
+	}
+	ln.onError(c, err)
+	return false
+}
+
+// goodbye 违反协议关闭连接前需要写出的数据
+func (ln *Listener) goodbye(c Conn, err error) []byte {
+	ln.lnet.Warn("违反协议，关闭连接！", zap.String("listener", ln.addr), zap.String("addr", c.GetAddr()), zap.Error(err))
+	if h, ok := ln.handlerOf(c).(ProtocolViolationHandler); ok {
+		if out := h.OnProtocolViolation(c, err); len(out) > 0 {
+			return ln.pack(c, out)
+		}
+	}
+	return nil
+}
+
+// inboundExceeded 未解包的数据是否超过限制
+func (ln *Listener) inboundExceeded(n int) bool {
+	max := ln.lnet.opts.MaxInboundBuffer
+	return max > 0 && n > max
+}
@@ -0,0 +1,83 @@
+package limnet
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestNewListenerAddr(t *testing.T) {
+	l := &LIMNet{opts: NewOption()}
+	tests := []struct {
+		addr      string
+		want      string
+		transport Transport
+		err       bool
+	}{
+		{addr: "tcp://127.0.0.1:17131", want: "127.0.0.1:17131", transport: TransportTCP},
+		{addr: "TCP://127.0.0.1:17131", want: "127.0.0.1:17131", transport: TransportTCP},
+		{addr: "127.0.0.1:17131", want: "127.0.0.1:17131", transport: TransportTCP},
+		{addr: "ws://0.0.0.0:17132", want: "0.0.0.0:17132", transport: TransportWS},
+		{addr: "ws://127.0.0.1", err: true},
+		{addr: "tcp://127.0.0.1:99999", err: true},
+		{addr: "udp://127.0.0.1:17131", err: true},
+		{addr: "wss://127.0.0.1:17133", err: true}, // 没有TLS配置
+	}
+	for _, tt := range tests {
+		ln, err := l.newListener(tt.addr, &TestHandler{}, nil)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expect error", tt.addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.addr, err)
+			continue
+		}
+		if ln.Addr() != tt.want || ln.Transport() != tt.transport {
+			t.Errorf("%s: expect %s %v but got %s %v", tt.addr, tt.want, tt.transport, ln.Addr(), ln.Transport())
+		}
+	}
+
+	if _, err := l.AddListener("tcp://127.0.0.1:17131", &TestHandler{}, nil, WithListenerTLS(&tls.Config{})); err != ErrTCPTLSUnsupported {
+		t.Fatalf("expect ErrTCPTLSUnsupported but got %v", err)
+	}
+}
+
+func TestDefaultListener(t *testing.T) {
+	var _ Packer = (*DefaultPacket)(nil)
+
+	l, err := NewServer(&TestHandler{}, WithAddr("tcp://127.0.0.1:17134"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Stop()
+	if ln := l.defaultListener(TransportTCP); ln != l.tcp.listener {
+		t.Fatal("expect the registered tcp listener")
+	}
+	// 没有开启websocket时兼容的构造函数使用全局配置
+	ws := NewWSServer(l)
+	if ws.listener.Transport() != TransportWS || ws.listener.EventHandler() != l.eventHandler {
+		t.Fatalf("unexpected listener %+v", ws.listener)
+	}
+	c := &WSConn{listener: l.tcp.listener}
+	if c.Listener() != "127.0.0.1:17134" {
+		t.Fatalf("expect 127.0.0.1:17134 but got %s", c.Listener())
+	}
+}
+
+func TestAddListenerAfterStart(t *testing.T) {
+	l, _ := startServer(t, &TestHandler{})
+	if _, err := l.AddListener("ws://127.0.0.1:0", &TestHandler{}, nil); err != ErrListenerAfterStart {
+		t.Fatalf("expect %v but got %v", ErrListenerAfterStart, err)
+	}
+	if _, err := l.AddListener("tcp://127.0.0.1:0", &TestHandler{}, nil); err != ErrListenerAfterStart {
+		t.Fatalf("expect %v but got %v", ErrListenerAfterStart, err)
+	}
+	if err := l.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.AddListener("tcp://127.0.0.1:0", &TestHandler{}, nil); err != ErrListenerAfterStart {
+		t.Fatalf("expect %v after stop but got %v", ErrListenerAfterStart, err)
+	}
+}
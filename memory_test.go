@@ -0,0 +1,145 @@
+package limnet
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+type memoryTestHandler struct {
+	DefaultEventHandler
+	closed   chan CloseReason
+	rejected chan RejectReason
+}
+
+func newMemoryTestHandler() *memoryTestHandler {
+	return &memoryTestHandler{closed: make(chan CloseReason, 100), rejected: make(chan RejectReason, 10)}
+}
+
+func (h *memoryTestHandler) OnPacket(c Conn, data []byte) []byte {
+	if string(data) == "big" { // 对端不读时积压在输出buffer里
+		return bigPayload()
+	}
+	return append(data, '\n')
+}
+
+func (h *memoryTestHandler) OnClose(c Conn) {
+	h.closed <- c.CloseReason()
+}
+
+func (h *memoryTestHandler) OnReject(addr string, reason RejectReason) {
+	h.rejected <- reason
+}
+
+// eventually 等待cond成立，超时则失败
+func eventually(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIdleConnNoBuffers(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		l, addr := startServer(t, newMemoryTestHandler(), WithPoller(backend), WithUnPacket(lineUnPacket))
+		conn := dial(t, addr)
+		r := bufio.NewReader(conn)
+		if _, err := conn.Write([]byte("hel")); err != nil {
+			t.Fatal(err)
+		}
+		// 不足一个包的数据放在输入buffer里
+		eventually(t, "expect partial packet buffered", func() bool { return l.BufferedBytes() > 0 })
+		if _, err := conn.Write([]byte("lo\n")); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		expectLines(t, r, "hello")
+		// 处理完、写完之后buffer都放回池里
+		eventually(t, "expect idle conn holding no buffers", func() bool { return l.BufferedBytes() == 0 })
+
+		conns := make([]io.ReadWriter, 0, 10)
+		for i := 0; i < 10; i++ {
+			c := dial(t, addr)
+			if _, err := c.Write([]byte("ping\n")); err != nil {
+				t.Fatal(err)
+			}
+			conns = append(conns, c)
+		}
+		for _, c := range conns {
+			expectLines(t, bufio.NewReader(c), "ping")
+		}
+		eventually(t, "expect idle conns holding no buffers", func() bool { return l.BufferedBytes() == 0 })
+	})
+}
+
+func TestRejectMemoryBudget(t *testing.T) {
+	h := newMemoryTestHandler()
+	l, addr := startServer(t, h, WithUnPacket(lineUnPacket), WithMaxBufferMemory(1024))
+	l.bufferResized(2048) // 模拟其他连接缓冲的内存超过了限制
+	conn := dial(t, addr)
+	if reason := recv(t, h.rejected); reason != RejectMemoryBudget {
+		t.Fatalf("expect %s but got %s", RejectMemoryBudget, reason)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expect rejected conn closed")
+	}
+
+	l.bufferResized(-2048)
+	conn = dial(t, addr)
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	expectLines(t, bufio.NewReader(conn), "ping")
+}
+
+func TestCloseMemoryBudget(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := newMemoryTestHandler()
+		l, addr := startServer(t, h, WithPoller(backend), WithUnPacket(lineUnPacket), WithMaxBufferMemory(256<<10))
+
+		// 一直发送不完整的包，输入buffer超过限制
+		sender := dial(t, addr)
+		go func() { _, _ = sender.Write(bytes.Repeat([]byte("x"), 1<<20)) }()
+		expectReason(t, h.closed, CloseMemoryBudget)
+
+		// 不读取回复，输出buffer超过限制
+		reader := dial(t, addr)
+		if _, err := reader.Write([]byte("big\n")); err != nil {
+			t.Fatal(err)
+		}
+		expectReason(t, h.closed, CloseMemoryBudget)
+		eventually(t, "expect buffers released", func() bool { return l.BufferedBytes() == 0 })
+	})
+}
+
+func TestEviction(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := newMemoryTestHandler()
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(lineUnPacket), WithEviction(1<<20, EvictLargestBacklog))
+		healthy := dial(t, addr)
+		slow := dial(t, addr)
+		if _, err := slow.Write([]byte("big\n")); err != nil {
+			t.Fatal(err)
+		}
+		// 慢消费者被驱逐
+		expectReason(t, h.closed, CloseEvictedBacklog)
+		if _, err := healthy.Write([]byte("ping\n")); err != nil {
+			t.Fatal(err)
+		}
+		_ = healthy.SetReadDeadline(time.Now().Add(3 * time.Second))
+		expectLines(t, bufio.NewReader(healthy), "ping")
+		select {
+		case reason := <-h.closed:
+			t.Fatalf("expect healthy conn kept open but closed: %s", reason)
+		case <-time.After(300 * time.Millisecond):
+		}
+	})
+}
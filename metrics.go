@@ -0,0 +1,284 @@
+package limnet
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tangtaoit/limnet/pkg/eventloop"
+	"github.com/tangtaoit/limnet/pkg/ringbuffer"
+)
+
+// Transport 连接的传输类型
+type Transport string
+
+const (
+	// TransportTCP tcp连接
+	TransportTCP Transport = "tcp"
+	// TransportWS websocket连接
+	TransportWS Transport = "ws"
+)
+
+var transports = []Transport{TransportTCP, TransportWS}
+
+func transportIndex(t Transport) int {
+	if t == TransportWS {
+		return 1
+	}
+	return 0
+}
+
+// Metrics 指标收集接口
+type Metrics interface {
+	// ConnAccepted 接受了一个新连接
+	ConnAccepted(t Transport)
+	// ConnClosed 连接已关闭
+	ConnClosed(t Transport)
+	// ConnRejected 连接在建立前被拒绝
+	ConnRejected(t Transport, reason RejectReason)
+	// BytesIn 读取到的字节数
+	BytesIn(t Transport, n int)
+	// BytesOut 写出的字节数
+	BytesOut(t Transport, n int)
+	// PacketIn 收到一个完整的数据包
+	PacketIn(t Transport)
+	// PacketOut 发送一个数据包
+	PacketOut(t Transport)
+	// EAGAIN 写数据遇到EAGAIN
+	EAGAIN(t Transport)
+	// OutboundBuffered 输出buffer积压的字节数变化（delta可为负数）
+	OutboundBuffered(delta int)
+	// ConnEvicted 缓冲的内存超过驱逐上限，驱逐了一个慢消费者连接
+	ConnEvicted(t Transport, reason CloseReason)
+}
+
+// MetricsExporter 可以将指标以文本格式输出的Metrics
+type MetricsExporter interface {
+	// Export 以prometheus文本格式输出指标
+	Export(w io.Writer)
+}
+
+// DefaultMetrics 默认的指标实现（基于原子计数）
+type DefaultMetrics struct {
+	accepted         [2]int64
+	closed           [2]int64
+	bytesIn          [2]int64
+	bytesOut         [2]int64
+	packetsIn        [2]int64
+	packetsOut       [2]int64
+	eagain           [2]int64
+	outboundBuffered int64
+
+	rejectedLock sync.Mutex
+	rejected     map[rejectKey]int64
+
+	evictedLock sync.Mutex
+	evicted     map[evictKey]int64
+}
+
+type rejectKey struct {
+	transport Transport
+	reason    RejectReason
+}
+
+type evictKey struct {
+	transport Transport
+	reason    CloseReason
+}
+
+// NewDefaultMetrics 创建默认的指标实现
+func NewDefaultMetrics() *DefaultMetrics {
+	return &DefaultMetrics{rejected: map[rejectKey]int64{}, evicted: map[evictKey]int64{}}
+}
+
+// ConnAccepted 接受了一个新连接
+func (m *DefaultMetrics) ConnAccepted(t Transport) {
+	atomic.AddInt64(&m.accepted[transportIndex(t)], 1)
+}
+
+// ConnClosed 连接已关闭
+func (m *DefaultMetrics) ConnClosed(t Transport) {
+	atomic.AddInt64(&m.closed[transportIndex(t)], 1)
+}
+
+// ConnRejected 连接在建立前被拒绝
+func (m *DefaultMetrics) ConnRejected(t Transport, reason RejectReason) {
+	m.rejectedLock.Lock()
+	m.rejected[rejectKey{transport: t, reason: reason}]++
+	m.rejectedLock.Unlock()
+}
+
+// BytesIn 读取到的字节数
+func (m *DefaultMetrics) BytesIn(t Transport, n int) {
+	atomic.AddInt64(&m.bytesIn[transportIndex(t)], int64(n))
+}
+
+// BytesOut 写出的字节数
+func (m *DefaultMetrics) BytesOut(t Transport, n int) {
+	atomic.AddInt64(&m.bytesOut[transportIndex(t)], int64(n))
+}
+
+// PacketIn 收到一个完整的数据包
+func (m *DefaultMetrics) PacketIn(t Transport) {
+	atomic.AddInt64(&m.packetsIn[transportIndex(t)], 1)
+}
+
+// PacketOut 发送一个数据包
+func (m *DefaultMetrics) PacketOut(t Transport) {
+	atomic.AddInt64(&m.packetsOut[transportIndex(t)], 1)
+}
+
+// EAGAIN 写数据遇到EAGAIN
+func (m *DefaultMetrics) EAGAIN(t Transport) {
+	atomic.AddInt64(&m.eagain[transportIndex(t)], 1)
+}
+
+// OutboundBuffered 输出buffer积压的字节数变化
+func (m *DefaultMetrics) OutboundBuffered(delta int) {
+	atomic.AddInt64(&m.outboundBuffered, int64(delta))
+}
+
+// ConnEvicted 驱逐了一个慢消费者连接
+func (m *DefaultMetrics) ConnEvicted(t Transport, reason CloseReason) {
+	m.evictedLock.Lock()
+	m.evicted[evictKey{transport: t, reason: reason}]++
+	m.evictedLock.Unlock()
+}
+
+// ActiveConns 当前活跃的连接数
+func (m *DefaultMetrics) ActiveConns(t Transport) int64 {
+	i := transportIndex(t)
+	return atomic.LoadInt64(&m.accepted[i]) - atomic.LoadInt64(&m.closed[i])
+}
+
+// Export 以prometheus文本格式输出指标
+func (m *DefaultMetrics) Export(w io.Writer) {
+	writeTransportMetric(w, "limnet_connections_accepted_total", "counter", "Total accepted connections.", &m.accepted)
+	writeTransportMetric(w, "limnet_connections_closed_total", "counter", "Total closed connections.", &m.closed)
+	writeMetricHeader(w, "limnet_connections_active", "gauge", "Currently active connections.")
+	for _, t := range transports {
+		fmt.Fprintf(w, "limnet_connections_active{transport=%q} %d\n", t, m.ActiveConns(t))
+	}
+	m.exportRejected(w)
+	writeTransportMetric(w, "limnet_bytes_in_total", "counter", "Total bytes read from connections.", &m.bytesIn)
+	writeTransportMetric(w, "limnet_bytes_out_total", "counter", "Total bytes written to connections.", &m.bytesOut)
+	writeTransportMetric(w, "limnet_packets_in_total", "counter", "Total packets received.", &m.packetsIn)
+	writeTransportMetric(w, "limnet_packets_out_total", "counter", "Total packets sent.", &m.packetsOut)
+	writeTransportMetric(w, "limnet_write_eagain_total", "counter", "Total writes that hit EAGAIN.", &m.eagain)
+	writeMetricHeader(w, "limnet_outbound_buffered_bytes", "gauge", "Bytes waiting in outbound buffers.")
+	fmt.Fprintf(w, "limnet_outbound_buffered_bytes %d\n", atomic.LoadInt64(&m.outboundBuffered))
+	m.exportEvicted(w)
+}
+
+func (m *DefaultMetrics) exportRejected(w io.Writer) {
+	m.rejectedLock.Lock()
+	keys := make([]rejectKey, 0, len(m.rejected))
+	for k := range m.rejected {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].transport != keys[j].transport {
+			return keys[i].transport < keys[j].transport
+		}
+		return keys[i].reason < keys[j].reason
+	})
+	writeMetricHeader(w, "limnet_connections_rejected_total", "counter", "Total connections rejected before being established.")
+	for _, k := range keys {
+		fmt.Fprintf(w, "limnet_connections_rejected_total{transport=%q,reason=%q} %d\n", k.transport, k.reason, m.rejected[k])
+	}
+	m.rejectedLock.Unlock()
+}
+
+func (m *DefaultMetrics) exportEvicted(w io.Writer) {
+	m.evictedLock.Lock()
+	keys := make([]evictKey, 0, len(m.evicted))
+	for k := range m.evicted {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].transport != keys[j].transport {
+			return keys[i].transport < keys[j].transport
+		}
+		return keys[i].reason < keys[j].reason
+	})
+	writeMetricHeader(w, "limnet_connections_evicted_total", "counter", "Total slow consumers evicted because buffer memory passed the eviction ceiling.")
+	for _, k := range keys {
+		fmt.Fprintf(w, "limnet_connections_evicted_total{transport=%q,reason=%q} %d\n", k.transport, k.reason, m.evicted[k])
+	}
+	m.evictedLock.Unlock()
+}
+
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeTransportMetric(w io.Writer, name, typ, help string, values *[2]int64) {
+	writeMetricHeader(w, name, typ, help)
+	for _, t := range transports {
+		fmt.Fprintf(w, "%s{transport=%q} %d\n", name, t, atomic.LoadInt64(&values[transportIndex(t)]))
+	}
+}
+
+// MetricsHandler 返回指标的http处理者（prometheus文本格式），可挂载到任意http mux上
+func (l *LIMNet) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		l.exportMetrics(w)
+	})
+}
+
+func (l *LIMNet) exportMetrics(w io.Writer) {
+	if exporter, ok := l.metrics.(MetricsExporter); ok {
+		exporter.Export(w)
+	}
+
+	loops := l.metricLoops()
+	writeMetricHeader(w, "limnet_loop_wakeups_total", "counter", "Total poller wakeups per event loop.")
+	for _, loop := range loops {
+		fmt.Fprintf(w, "limnet_loop_wakeups_total{loop=%q} %d\n", loop.label, loop.stats.Wakeups)
+	}
+	writeMetricHeader(w, "limnet_loop_panics_total", "counter", "Total panics recovered in handleEvent per event loop.")
+	for _, loop := range loops {
+		fmt.Fprintf(w, "limnet_loop_panics_total{loop=%q} %d\n", loop.label, loop.stats.Panics)
+	}
+	writeMetricHeader(w, "limnet_loop_job_queue_length", "gauge", "Jobs waiting in the async job queue per event loop.")
+	for _, loop := range loops {
+		fmt.Fprintf(w, "limnet_loop_job_queue_length{loop=%q} %d\n", loop.label, loop.stats.JobQueueLen)
+	}
+	writeMetricHeader(w, "limnet_loop_job_latency_seconds", "summary", "Time jobs spent waiting in the async job queue.")
+	for _, loop := range loops {
+		fmt.Fprintf(w, "limnet_loop_job_latency_seconds_sum{loop=%q} %g\n", loop.label, loop.stats.JobLatency.Seconds())
+		fmt.Fprintf(w, "limnet_loop_job_latency_seconds_count{loop=%q} %d\n", loop.label, loop.stats.JobsExecuted)
+	}
+
+	writeMetricHeader(w, "limnet_buffer_memory_bytes", "gauge", "Memory held by connection inbound and outbound buffers.")
+	fmt.Fprintf(w, "limnet_buffer_memory_bytes %d\n", l.BufferedBytes())
+
+	hits, misses := ringbuffer.Stats()
+	writeMetricHeader(w, "limnet_ringbuffer_pool_hits_total", "counter", "Ringbuffers reused from the pool.")
+	fmt.Fprintf(w, "limnet_ringbuffer_pool_hits_total %d\n", hits)
+	writeMetricHeader(w, "limnet_ringbuffer_pool_misses_total", "counter", "Ringbuffers newly allocated because the pool was empty.")
+	fmt.Fprintf(w, "limnet_ringbuffer_pool_misses_total %d\n", misses)
+}
+
+// loopMetric 一个eventloop的指标
+type loopMetric struct {
+	label string // 连接的eventloop为序号，监听的eventloop为listener
+	stats eventloop.Stats
+}
+
+// metricLoops 所有eventloop的指标（连接的eventloop和监听的eventloop）
+func (l *LIMNet) metricLoops() []loopMetric {
+	loops := make([]loopMetric, 0, len(l.connectLoops)+1)
+	for i, loop := range l.connectLoops {
+		loops = append(loops, loopMetric{label: strconv.Itoa(i), stats: loop.Stats()})
+	}
+	if l.listenerLoop != nil {
+		loops = append(loops, loopMetric{label: "listener", stats: l.listenerLoop.Stats()})
+	}
+	return loops
+}
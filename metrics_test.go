@@ -0,0 +1,118 @@
+package limnet
+
+import (
+	"bufio"
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// metricValue 从prometheus文本里取出一行指标的值，没有返回空
+func metricValue(text, series string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, series+" ") {
+			return strings.TrimPrefix(line, series+" ")
+		}
+	}
+	return ""
+}
+
+func TestDefaultMetrics_Export(t *testing.T) {
+	m := NewDefaultMetrics()
+	m.ConnAccepted(TransportTCP)
+	m.ConnAccepted(TransportTCP)
+	m.ConnAccepted(TransportWS)
+	m.ConnClosed(TransportTCP)
+	m.BytesIn(TransportTCP, 10)
+	m.BytesOut(TransportWS, 7)
+	m.PacketIn(TransportTCP)
+	m.PacketOut(TransportTCP)
+	m.EAGAIN(TransportTCP)
+	m.OutboundBuffered(100)
+	m.OutboundBuffered(-40)
+	m.ConnRejected(TransportWS, RejectMaxConns)
+	m.ConnRejected(TransportTCP, RejectACL)
+	m.ConnRejected(TransportTCP, RejectACL)
+	m.ConnEvicted(TransportTCP, CloseEvictedBacklog)
+
+	var buf bytes.Buffer
+	m.Export(&buf)
+	text := buf.String()
+	want := map[string]string{
+		`limnet_connections_accepted_total{transport="tcp"}`:                         "2",
+		`limnet_connections_accepted_total{transport="ws"}`:                          "1",
+		`limnet_connections_closed_total{transport="tcp"}`:                           "1",
+		`limnet_connections_active{transport="tcp"}`:                                 "1",
+		`limnet_connections_active{transport="ws"}`:                                  "1",
+		`limnet_bytes_in_total{transport="tcp"}`:                                     "10",
+		`limnet_bytes_out_total{transport="ws"}`:                                     "7",
+		`limnet_packets_in_total{transport="tcp"}`:                                   "1",
+		`limnet_packets_out_total{transport="tcp"}`:                                  "1",
+		`limnet_write_eagain_total{transport="tcp"}`:                                 "1",
+		`limnet_outbound_buffered_bytes`:                                             "60",
+		`limnet_connections_rejected_total{transport="tcp",reason="acl"}`:            "2",
+		`limnet_connections_rejected_total{transport="ws",reason="max_conns"}`:       "1",
+		`limnet_connections_evicted_total{transport="tcp",reason="evicted_backlog"}`: "1",
+	}
+	for series, v := range want {
+		if got := metricValue(text, series); got != v {
+			t.Errorf("%s: expect %s but got %q", series, v, got)
+		}
+	}
+	// 每个指标都有HELP和TYPE
+	if !strings.Contains(text, "# HELP limnet_connections_accepted_total Total accepted connections.\n# TYPE limnet_connections_accepted_total counter\n") {
+		t.Error("expect HELP and TYPE headers")
+	}
+	// 带标签的指标按transport、reason排序输出
+	if strings.Index(text, `transport="tcp",reason="acl"`) > strings.Index(text, `transport="ws",reason="max_conns"`) {
+		t.Error("expect rejected series sorted")
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	l, addr := startServer(t, newHalfCloseTestHandler(), WithUnPacket(lineUnPacket))
+	conn := dial(t, addr)
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	expectLines(t, bufio.NewReader(conn), "hello")
+
+	rec := httptest.NewRecorder()
+	l.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	text := rec.Body.String()
+	want := map[string]string{
+		`limnet_connections_accepted_total{transport="tcp"}`: "1",
+		`limnet_connections_active{transport="tcp"}`:         "1",
+		`limnet_bytes_in_total{transport="tcp"}`:             "6",
+		`limnet_bytes_out_total{transport="tcp"}`:            "6",
+		`limnet_packets_in_total{transport="tcp"}`:           "1",
+		`limnet_packets_out_total{transport="tcp"}`:          "1",
+		`limnet_buffer_memory_bytes`:                         "0",
+	}
+	for series, v := range want {
+		if got := metricValue(text, series); got != v {
+			t.Errorf("%s: expect %s but got %q", series, v, got)
+		}
+	}
+	// 监听的eventloop也导出（接受连接时被唤醒过）
+	if v := metricValue(text, `limnet_loop_wakeups_total{loop="listener"}`); v == "" || v == "0" {
+		t.Errorf("expect listener loop wakeups but got %q", v)
+	}
+	for _, series := range []string{`limnet_loop_panics_total{loop="0"}`, `limnet_loop_panics_total{loop="listener"}`, `limnet_loop_job_queue_length{loop="listener"}`} {
+		if metricValue(text, series) == "" {
+			t.Errorf("expect %s exported", series)
+		}
+	}
+	// 每行都是注释或者 名字 值
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if !strings.HasPrefix(line, "# ") && len(strings.Fields(line)) != 2 {
+			t.Errorf("malformed line %q", line)
+		}
+	}
+}
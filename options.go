@@ -2,48 +2,76 @@ package limnet
 
 import (
 	"time"
+
+	"github.com/tangtaoit/limnet/pkg/acl"
 )
 
 // Options 配置
 type Options struct {
-	Addr              string        // 连接地址 例如 tcp://127.0.0.1:6666
-	WSAddr            string        // websocket的地址
-	SSLOn             bool          // websocket是否开启 ssl
-	SSLCertificate    string        // websocket的ssl证书 （开启ssl必须要配置）
-	SSLCertificateKey string        // websocket的ssl证书key （开启ssl必须要配置）
-	ConnEventLoopNum  int           // 连接事件loop数量 ， 如果为小于或等于0则为 runtime.NumCPU() 的值
-	TimingWheelTick   time.Duration // 时间轮轮训间隔 必须大于等于1ms
-	TimingWheelSize   int64         // 时间轮大小
-	ConnIdleTime      time.Duration // 连接闲置时间，如果大于此闲置时间将自动关闭连接
-	unPacket          UnPacket      // 协议
+	Addr               string             // 连接地址 例如 tcp://0.0.0.0:6666（默认，监听所有地址），指定host则只监听该地址，只支持IPv4
+	WSAddr             string             // websocket的地址，为空则不开启websocket服务（默认为空）
+	SSLOn              bool               // websocket是否开启 ssl
+	SSLCertificate     string             // websocket的ssl证书 （开启ssl必须要配置）
+	SSLCertificateKey  string             // websocket的ssl证书key （开启ssl必须要配置）
+	ConnEventLoopNum   int                // 连接事件loop数量 ， 如果为小于或等于0则为 runtime.NumCPU() 的值
+	TimingWheelTick    time.Duration      // 时间轮轮训间隔 必须大于等于1ms
+	TimingWheelSize    int64              // 时间轮大小
+	ConnIdleTime       time.Duration      // 连接闲置时间，如果大于此闲置时间将自动关闭连接
+	Metrics            Metrics            `json:"-"` // 指标收集，为nil则使用 DefaultMetrics
+	MetricsPath        string             // 指标的http路径，设置后将挂载到websocket服务上
+	MetricsAddr        string             // 指标独立的http监听地址，为空则不开启
+	AdminOn            bool               // 是否开启管理接口（默认关闭）
+	AdminAddr          string             // 管理接口独立的http监听地址，为空则挂载到websocket服务上（此时必须设置AdminToken）
+	AdminToken         string             `json:"-"` // 管理接口的访问token，为空则不校验（只允许独立监听时为空）
+	MaxConns           int                // 最大连接数，小于等于0则不限制
+	MaxConnsPerIP      int                // 单个IP的最大连接数，小于等于0则不限制
+	AcceptRate         float64            // 每秒允许接受的连接数（令牌桶），小于等于0则不限制
+	AcceptBurst        int                // 接受连接的突发数量
+	PacketRate         float64            // 单个连接每秒允许处理的包数量（令牌桶），小于等于0则不限制
+	PacketBurst        int                // 单个连接包的突发数量
+	PacketRateAction   RateAction         // 连接超过包速率后的处理方式
+	MaxInboundBuffer   int                // 单个连接未解包数据的最大字节数，超过后按违反协议关闭连接，小于等于0则不限制
+	MaxBufferMemory    int64              // 所有连接的输入输出buffer最多占用的内存，超过后拒绝新连接并关闭继续缓冲数据的连接，小于等于0则不限制
+	EvictMemory        int64              // 所有连接的输入输出buffer占用的内存超过此值时驱逐慢消费者，小于等于0则不驱逐
+	EvictPolicy        EvictPolicy        // 驱逐慢消费者的策略
+	Poller             PollerBackend      // eventloop使用的IO模型（默认epoll/kqueue）
+	WriteCoalescing    bool               // 合并写，一轮事件循环里的写入先进输出buffer，结束时每个连接只写一次
+	BufferDebug        bool               // Buffer调试模式，检测释放后继续使用（进程内所有Buffer生效，有性能损耗）
+	ACL                *acl.ACL           `json:"-"` // 连接的ip访问控制，为nil则允许所有ip
+	ProxyProtocol      ProxyProtocolMode  // PROXY协议模式（websocket则对应X-Forwarded-For/X-Real-IP）
+	ProxyTrusted       *acl.ACL           `json:"-"` // 信任的代理地址，为nil则不信任任何来源（开启PROXY协议时必须设置）
+	ConnHandlerFactory ConnHandlerFactory `json:"-"` // 每个连接的处理者工厂，为nil则所有连接由EventHandler处理
+	unPacket           UnPacket           // 协议
 }
 
+// PollerBackend eventloop使用的IO模型
+type PollerBackend int
+
+const (
+	// PollerEpoll 就绪模型 linux为epoll，其他系统为kqueue（默认）
+	PollerEpoll PollerBackend = iota
+	// PollerIOURing 完成模型 使用io_uring（需要linux 6.0+），不可用时自动回退到PollerEpoll
+	PollerIOURing
+)
+
 // Option 参数项
 type Option func(*Options) error
 
 // NewOption 创建一个默认配置
 func NewOption() *Options {
 	return &Options{
-		Addr:             "tcp://127.0.0.1:6666",
-		WSAddr:           "0.0.0.0:8030",
+		Addr:             "tcp://0.0.0.0:6666",
+		WSAddr:           "",
 		SSLOn:            false,
 		ConnEventLoopNum: 0,
 		TimingWheelTick:  time.Millisecond * 10,
 		TimingWheelSize:  1000,
 		ConnIdleTime:     70 * time.Second,
-		unPacket: func(c Conn) ([]byte, error) {
-			buf := c.Read()
-			if len(buf) == 0 {
-				return nil, nil
-			}
-			// fmt.Println("len->", len(buf))
-			c.ResetBuffer()
-			return buf, nil
-		},
+		unPacket:         defaultUnPacket,
 	}
 }
 
-// WithAddr 设置tcp连接地址
+// WithAddr 设置tcp连接地址 默认tcp://0.0.0.0:6666监听所有地址，只想监听本机时使用 tcp://127.0.0.1:端口
 func WithAddr(addr string) Option {
 	return func(opts *Options) error {
 		opts.Addr = addr
@@ -51,7 +79,7 @@ func WithAddr(addr string) Option {
 	}
 }
 
-// WithWSAddr 设置websocket连接地址
+// WithWSAddr 设置websocket连接地址，设置后才会开启websocket服务
 func WithWSAddr(wsaddr string) Option {
 	return func(opts *Options) error {
 		opts.WSAddr = wsaddr
@@ -75,6 +103,164 @@ func WithConnIdleTime(connIdleTime time.Duration) Option {
 	}
 }
 
+// WithMetrics 设置指标收集
+func WithMetrics(metrics Metrics) Option {
+	return func(opts *Options) error {
+		opts.Metrics = metrics
+		return nil
+	}
+}
+
+// WithMetricsPath 设置指标的http路径（挂载到websocket服务上）
+func WithMetricsPath(path string) Option {
+	return func(opts *Options) error {
+		opts.MetricsPath = path
+		return nil
+	}
+}
+
+// WithMetricsAddr 设置指标独立的http监听地址
+func WithMetricsAddr(addr string) Option {
+	return func(opts *Options) error {
+		opts.MetricsAddr = addr
+		return nil
+	}
+}
+
+// WithAdmin 开启管理接口 addr为空则挂载到websocket服务上（此时token不能为空），token为空则不校验，请求时通过 Authorization: Bearer <token> 传递
+func WithAdmin(addr string, token string) Option {
+	return func(opts *Options) error {
+		opts.AdminOn = true
+		opts.AdminAddr = addr
+		opts.AdminToken = token
+		return nil
+	}
+}
+
+// WithMaxConns 设置最大连接数
+func WithMaxConns(maxConns int) Option {
+	return func(opts *Options) error {
+		opts.MaxConns = maxConns
+		return nil
+	}
+}
+
+// WithMaxConnsPerIP 设置单个IP的最大连接数
+func WithMaxConnsPerIP(maxConnsPerIP int) Option {
+	return func(opts *Options) error {
+		opts.MaxConnsPerIP = maxConnsPerIP
+		return nil
+	}
+}
+
+// WithAcceptRate 设置每秒允许接受的连接数和突发数量
+func WithAcceptRate(rate float64, burst int) Option {
+	return func(opts *Options) error {
+		opts.AcceptRate = rate
+		opts.AcceptBurst = burst
+		return nil
+	}
+}
+
+// WithPacketRate 设置单个连接每秒允许处理的包数量、突发数量和超过后的处理方式
+func WithPacketRate(rate float64, burst int, action RateAction) Option {
+	return func(opts *Options) error {
+		opts.PacketRate = rate
+		opts.PacketBurst = burst
+		opts.PacketRateAction = action
+		return nil
+	}
+}
+
+// WithMaxInboundBuffer 设置单个连接未解包数据的最大字节数
+func WithMaxInboundBuffer(maxInboundBuffer int) Option {
+	return func(opts *Options) error {
+		opts.MaxInboundBuffer = maxInboundBuffer
+		return nil
+	}
+}
+
+// WithMaxBufferMemory 设置所有连接的输入输出buffer最多占用的内存（字节）
+func WithMaxBufferMemory(maxBufferMemory int64) Option {
+	return func(opts *Options) error {
+		opts.MaxBufferMemory = maxBufferMemory
+		return nil
+	}
+}
+
+// WithEviction 设置驱逐慢消费者的内存上限和策略 一般小于MaxBufferMemory，先驱逐慢消费者再拒绝新连接
+func WithEviction(evictMemory int64, policy EvictPolicy) Option {
+	return func(opts *Options) error {
+		opts.EvictMemory = evictMemory
+		opts.EvictPolicy = policy
+		return nil
+	}
+}
+
+// WithPoller 设置eventloop使用的IO模型
+func WithPoller(backend PollerBackend) Option {
+	return func(opts *Options) error {
+		opts.Poller = backend
+		return nil
+	}
+}
+
+// WithWriteCoalescing 开启合并写 延迟敏感的写入可以调用Conn.Flush立即写出
+func WithWriteCoalescing(on bool) Option {
+	return func(opts *Options) error {
+		opts.WriteCoalescing = on
+		return nil
+	}
+}
+
+// WithBufferDebug 开启Buffer调试模式 释放后的Buffer不再复用并填充0xdd，继续使用会panic并带上释放时的堆栈
+func WithBufferDebug(on bool) Option {
+	return func(opts *Options) error {
+		opts.BufferDebug = on
+		return nil
+	}
+}
+
+// WithConnHandlerFactory 设置连接处理者工厂 每个连接的OnPacket/OnClose交给factory创建的处理者
+func WithConnHandlerFactory(factory ConnHandlerFactory) Option {
+	return func(opts *Options) error {
+		opts.ConnHandlerFactory = factory
+		return nil
+	}
+}
+
+// WithACL 设置ip访问控制 allow和deny为CIDR列表
+func WithACL(allow []string, deny []string) Option {
+	return func(opts *Options) error {
+		a, err := acl.New(allow, deny)
+		if err != nil {
+			return err
+		}
+		opts.ACL = a
+		return nil
+	}
+}
+
+// WithProxyProtocol 设置PROXY协议模式和信任的代理CIDR列表，开启时trusted不能为空（只有来自信任代理的地址信息才会被采用）
+func WithProxyProtocol(mode ProxyProtocolMode, trusted []string) Option {
+	return func(opts *Options) error {
+		opts.ProxyProtocol = mode
+		if len(trusted) == 0 {
+			opts.ProxyTrusted = nil
+			if mode != ProxyProtocolOff {
+				return ErrProxyTrustedRequired
+			}
+			return nil
+		}
+		a, err := acl.New(trusted, nil)
+		if err != nil {
+			return err
+		}
+		opts.ProxyTrusted = a
+		return nil
+	}
+}
+
 func WithSSLOn(sslOn bool) Option {
 	return func(opts *Options) error {
 		opts.SSLOn = sslOn
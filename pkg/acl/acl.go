@@ -0,0 +1,103 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ACL 基于CIDR的访问控制列表（支持IPv4和IPv6），创建后只读，可并发使用
+// 规则：命中deny则拒绝；allow为空则允许，否则必须命中allow才允许
+type ACL struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// New 创建ACL allow和deny为CIDR列表（例如 10.0.0.0/8, fd00::/8），单个IP会按/32或/128处理
+func New(allow []string, deny []string) (*ACL, error) {
+	a := &ACL{}
+	var err error
+	if a.allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if a.deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Allowed ip是否允许访问，nil的ACL允许所有ip
+func (a *ACL) Allowed(ip net.IP) bool {
+	if a == nil {
+		return true
+	}
+	if ip == nil {
+		return len(a.allow) == 0 && len(a.deny) == 0
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if contains(a.deny, ip) {
+		return false
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	return contains(a.allow, ip)
+}
+
+// AllowedAddr 地址（ip或ip:port）是否允许访问
+func (a *ACL) AllowedAddr(addr string) bool {
+	if a == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return a.Allowed(net.ParseIP(host))
+}
+
+// String 返回ACL的规则描述
+func (a *ACL) String() string {
+	if a == nil {
+		return "allow all"
+	}
+	return fmt.Sprintf("allow=%v deny=%v", a.allow, a.deny)
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("acl: invalid ip %q", cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("acl: invalid cidr %q: %w", cidr, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
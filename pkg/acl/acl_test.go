@@ -0,0 +1,53 @@
+package acl
+
+import (
+	"net"
+	"testing"
+)
+
+func TestACL_Allowed(t *testing.T) {
+	a, err := New([]string{"10.0.0.0/8", "fd00::/8", "192.168.1.10"}, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"10.0.0.1":        true,
+		"10.1.2.3":        false,
+		"192.168.1.10":    true,
+		"192.168.1.11":    false,
+		"fd00::1":         true,
+		"2001:db8::1":     false,
+		"::ffff:10.0.0.1": true,
+	}
+	for ip, want := range cases {
+		if got := a.Allowed(net.ParseIP(ip)); got != want {
+			t.Fatalf("Allowed(%s) expect %v but got %v", ip, want, got)
+		}
+	}
+	if !a.AllowedAddr("10.0.0.1:1234") || a.AllowedAddr("[2001:db8::1]:80") {
+		t.Fatal("AllowedAddr mismatch")
+	}
+}
+
+func TestACL_DenyOnly(t *testing.T) {
+	a, err := New(nil, []string{"1.2.3.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Allowed(net.ParseIP("1.2.3.4")) || !a.Allowed(net.ParseIP("4.3.2.1")) {
+		t.Fatal("deny only acl mismatch")
+	}
+	var nilACL *ACL
+	if !nilACL.Allowed(net.ParseIP("1.2.3.4")) {
+		t.Fatal("nil acl should allow all")
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	if _, err := New([]string{"not-an-ip"}, nil); err == nil {
+		t.Fatal("expect error for invalid ip")
+	}
+	if _, err := New(nil, []string{"10.0.0.0/99"}); err == nil {
+		t.Fatal("expect error for invalid cidr")
+	}
+}
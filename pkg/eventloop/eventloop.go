@@ -2,11 +2,10 @@ package eventloop
 
 import (
 	"sync"
+	"time"
 
-	"github.com/pkg/errors"
 	"github.com/tangtaoit/limnet/pkg/limlog"
 	"github.com/tangtaoit/limnet/pkg/limpoller"
-	"github.com/tangtaoit/limnet/pkg/limutil"
 	"github.com/tangtaoit/limnet/pkg/limutil/sync/atomic"
 	"go.uber.org/zap"
 )
@@ -26,17 +25,71 @@ type EventHandler interface {
 	Close() error
 }
 
+// ErrorHandler 可选接口，EventHandler实现此接口后Handle中的panic会以PanicError交给HandleError处理
+type ErrorHandler interface {
+	HandleError(err error)
+}
+
+// Flusher 通过MarkDirty登记（一轮事件循环结束时）或者TriggerFlush入队（其他goroutine写入后）调用FlushWrites写出数据
+type Flusher interface {
+	FlushWrites()
+}
+
+// Op io_uring模式下提交的操作类型
+type Op uint8
+
+const (
+	// OpAccept multishot accept
+	OpAccept Op = iota + 1
+	// OpRecv multishot recv
+	OpRecv
+	// OpSend send
+	OpSend
+)
+
+// CompletionHandler io_uring模式下的处理者 通过id绑定，提交的操作完成后调用HandleCompletion
+type CompletionHandler interface {
+	// HandleCompletion 操作完成 res为操作的返回值（负数为-errno），more表示multishot操作还会继续产生完成事件，buf为recv读到的数据（只在调用期间有效）
+	HandleCompletion(op Op, res int32, more bool, buf []byte)
+	Close() error
+}
+
+// maxJobsPerIteration 每轮事件循环最多执行的job数量，剩余的留到下一轮，避免job太多时事件得不到处理
+const maxJobsPerIteration = 4096
+
+// io_uring模式的默认参数
+const (
+	ringEntries  = 1024
+	ringBufCount = 256
+	ringBufSize  = 16 * 1024
+)
+
 // EventLoop 事件循环
 type EventLoop struct {
 	poller        *limpoller.Poller
+	ring          *limpoller.Ring // io_uring模式下不为nil（此时poller为nil）
 	asyncJobQueue AsyncJobQueue
-	handlers      sync.Map         // 处理者集合
-	packet        []byte           // 包缓存
-	jobLock       limutil.SpinLock // job自旋锁
-	eventHandling atomic.Bool      // 事件是否处理中
+	handlers      sync.Map        // 处理者集合
+	packet        []byte          // 包缓存
+	wakePending   atomic.Bool     // 已经唤醒过或者eventloop正在处理事件，Trigger不需要再唤醒
+	jobBudget     int             // 本轮事件循环还能执行的job数量
+	panics        atomic.Int64    // handleEvent恢复的panic次数
+	handlerCount  atomic.Int64    // 绑定的处理者数量
+	onError       func(err error) // job错误和没有ErrorHandler的处理者的panic
+	dirty         []Flusher       // 本轮事件循环结束时需要flush的处理者
 	limlog.Log
 }
 
+// Stats eventloop运行统计
+type Stats struct {
+	Wakeups      int64         // poller被唤醒的次数
+	Panics       int64         // handleEvent恢复的panic次数
+	Handlers     int64         // 绑定的处理者数量
+	JobQueueLen  int           // 待执行的job数量
+	JobsExecuted int64         // 已执行的job数量
+	JobLatency   time.Duration // job从入队到执行的累计等待时间
+}
+
 // New 创建
 func New() (*EventLoop, error) {
 	p, err := limpoller.Create()
@@ -44,12 +97,33 @@ func New() (*EventLoop, error) {
 		return nil, err
 	}
 
-	return &EventLoop{
+	l := &EventLoop{
 		poller:        p,
 		asyncJobQueue: NewAsyncJobQueue(),
 		Log:           limlog.NewLIMLog("EventLoop"),
 		packet:        make([]byte, 0xFFFF),
-	}, nil
+		jobBudget:     maxJobsPerIteration,
+	}
+	p.SetIterationHook(l.endIteration)
+	return l, nil
+}
+
+// NewRing 创建使用io_uring的事件循环（完成模型），处理者需要通过BindCompletionHandler绑定并自己提交操作
+func NewRing() (*EventLoop, error) {
+	r, err := limpoller.NewRing(ringEntries, ringBufCount, ringBufSize)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &EventLoop{
+		ring:          r,
+		asyncJobQueue: NewAsyncJobQueue(),
+		Log:           limlog.NewLIMLog("EventLoop"),
+		packet:        make([]byte, 0xFFFF),
+		jobBudget:     maxJobsPerIteration,
+	}
+	r.SetIterationHook(l.endIteration)
+	return l, nil
 }
 
 // PacketBuf 内部使用，临时缓冲区
@@ -57,13 +131,46 @@ func (l *EventLoop) PacketBuf() []byte {
 	return l.packet
 }
 
-// Poller 获取Poller对象
+// Poller 获取Poller对象 io_uring模式下为nil
 func (l *EventLoop) Poller() *limpoller.Poller {
 	return l.poller
 }
 
+// IsRing 是否是io_uring模式
+func (l *EventLoop) IsRing() bool {
+	return l.ring != nil
+}
+
+// Stats 获取eventloop运行统计
+func (l *EventLoop) Stats() Stats {
+	executed, latency := l.asyncJobQueue.Executed()
+	var wakeups int64
+	if l.ring != nil {
+		wakeups = l.ring.Wakeups()
+	} else {
+		wakeups = l.poller.Wakeups()
+	}
+	return Stats{
+		Wakeups:      wakeups,
+		Panics:       l.panics.Get(),
+		Handlers:     l.handlerCount.Get(),
+		JobQueueLen:  l.asyncJobQueue.Len(),
+		JobsExecuted: executed,
+		JobLatency:   latency,
+	}
+}
+
+// SetErrorHandler 设置错误处理 接收job返回的错误、job中的panic以及没有实现ErrorHandler的处理者的panic（需要在Run之前设置）
+func (l *EventLoop) SetErrorHandler(onError func(err error)) {
+	l.onError = onError
+}
+
 // Run 运行事件循环
 func (l *EventLoop) Run() {
+	if l.ring != nil {
+		l.ring.Run(l.handleCompletion)
+		return
+	}
 	l.poller.Poll(l.handleEvent)
 }
 
@@ -71,7 +178,7 @@ func (l *EventLoop) Run() {
 func (l *EventLoop) Stop() error {
 	l.handlers.Range(func(key, value interface{}) bool {
 
-		s, ok := value.(EventHandler)
+		s, ok := value.(interface{ Close() error })
 		if !ok {
 			l.Error("value.(Socket) fail")
 		} else {
@@ -81,6 +188,9 @@ func (l *EventLoop) Stop() error {
 		}
 		return true
 	})
+	if l.ring != nil {
+		return l.ring.Close()
+	}
 	return l.poller.Close()
 }
 
@@ -93,6 +203,7 @@ func (l *EventLoop) BindHandler(fd int, h EventHandler) error {
 		l.Error("绑定fd失败！", zap.Error(err))
 		return err
 	}
+	l.handlerCount.Add(1)
 
 	return nil
 }
@@ -102,33 +213,202 @@ func (l *EventLoop) DeleteFdInLoop(fd int) {
 	if err := l.poller.Del(fd); err != nil {
 		limlog.Error("[DeleteFdInLoop]", zap.Error(err))
 	}
+	if _, ok := l.handlers.Load(fd); ok {
+		l.handlerCount.Add(-1)
+	}
 	l.handlers.Delete(fd)
 }
 
 // Trigger 将job推入队列，然后唤醒eventloop去执行job 从而达到串行的目的，避免了race
+// 只有wakePending从false变为true的生产者才会唤醒，一轮事件循环最多唤醒一次
 func (l *EventLoop) Trigger(job Job) error {
 	l.asyncJobQueue.Push(job)
-	if !l.eventHandling.Get() {
-		return l.poller.Wake()
+	if l.wakePending.CompareAndSwap(false, true) {
+		return l.wake()
 	}
 	return nil
 }
 
-func (l *EventLoop) handleEvent(fd int, events limpoller.Event) {
-	defer func() {
-		if err := recover(); err != nil {
-			limlog.Warn("EventLoop处理handleEvent遇到异常！", zap.Error(errors.Wrap(err.(error), "EventHandler遇到异常，请检查代码！")))
+// TriggerFlush 和Trigger一样按顺序入队，执行时调用f.FlushWrites，不需要为每次写入分配闭包
+func (l *EventLoop) TriggerFlush(f Flusher) error {
+	l.asyncJobQueue.PushFlusher(f)
+	if l.wakePending.CompareAndSwap(false, true) {
+		return l.wake()
+	}
+	return nil
+}
 
+func (l *EventLoop) wake() error {
+	if l.ring != nil {
+		return l.ring.Wake()
+	}
+	return l.poller.Wake()
+}
+
+// runJobs 在本轮的预算内执行job
+func (l *EventLoop) runJobs() {
+	if l.jobBudget > 0 {
+		l.jobBudget -= l.asyncJobQueue.ExecuteJobs(l.jobBudget, l.handleJobError)
+	}
+}
+
+// endIteration 一轮事件循环结束（之后将进入等待）
+func (l *EventLoop) endIteration() {
+	l.runJobs()
+	// 先清除wakePending再检查一次队列，之后入队的job由生产者唤醒，不会遗漏
+	l.wakePending.Set(false)
+	l.runJobs()
+	l.flushDirty()
+	if l.asyncJobQueue.Len() > 0 && l.wakePending.CompareAndSwap(false, true) { // 超出预算的job留到下一轮
+		if err := l.wake(); err != nil {
+			l.Error("唤醒eventloop失败！", zap.Error(err))
+		}
+	}
+	l.jobBudget = maxJobsPerIteration
+}
+
+// MarkDirty 登记本轮事件循环结束时需要flush的处理者，只能在eventloop里调用
+func (l *EventLoop) MarkDirty(f Flusher) {
+	l.dirty = append(l.dirty, f)
+}
+
+// flushDirty 一轮事件循环结束，flush登记的处理者
+func (l *EventLoop) flushDirty() {
+	for i := 0; i < len(l.dirty); i++ { // flush期间可能会有新的登记
+		l.callFlusher(l.dirty[i])
+		l.dirty[i] = nil
+	}
+	l.dirty = l.dirty[:0]
+}
+
+// callFlusher 调用FlushWrites，恢复其中的panic
+func (l *EventLoop) callFlusher(f Flusher) {
+	defer func() {
+		if r := recover(); r != nil {
+			l.panics.Add(1)
+			err := NewPanicError(r)
+			l.Warn("EventLoop处理FlushWrites遇到异常，请检查代码！", zap.Error(err))
+			if eh, ok := f.(ErrorHandler); ok {
+				eh.HandleError(err)
+			} else if l.onError != nil {
+				l.onError(err)
+			}
 		}
 	}()
-	l.eventHandling.Set(true)
+	f.FlushWrites()
+}
+
+// BindCompletionHandler io_uring模式下绑定id对应的处理者（id需要小于2^56）
+func (l *EventLoop) BindCompletionHandler(id uint64, h CompletionHandler) {
+	if _, loaded := l.handlers.LoadOrStore(id, h); !loaded {
+		l.handlerCount.Add(1)
+	}
+}
+
+// DeleteCompletionHandler 删除id对应的处理者 之后到达的完成事件会被丢弃
+func (l *EventLoop) DeleteCompletionHandler(id uint64) {
+	if _, ok := l.handlers.Load(id); ok {
+		l.handlerCount.Add(-1)
+	}
+	l.handlers.Delete(id)
+}
+
+// SubmitAccept 提交multishot accept 完成事件的res为接受的连接fd（非阻塞）
+func (l *EventLoop) SubmitAccept(id uint64, fd int) error {
+	return l.ring.Accept(fd, ringUserData(id, OpAccept))
+}
+
+// SubmitRecv 提交multishot recv
+func (l *EventLoop) SubmitRecv(id uint64, fd int) error {
+	return l.ring.Recv(fd, ringUserData(id, OpRecv))
+}
+
+// SubmitSend 按顺序发送bufs（链接的send），每个buf产生一个OpSend完成事件，完成前bufs不能修改
+func (l *EventLoop) SubmitSend(id uint64, fd int, bufs [][]byte) error {
+	return l.ring.Send(fd, bufs, ringUserData(id, OpSend))
+}
+
+// SubmitCancel 取消id的op操作
+func (l *EventLoop) SubmitCancel(id uint64, op Op) error {
+	return l.ring.Cancel(ringUserData(id, op))
+}
+
+func ringUserData(id uint64, op Op) uint64 {
+	return id<<8 | uint64(op)
+}
+
+func (l *EventLoop) handleEvent(fd int, events limpoller.Event) {
+	l.awake()
 	if fd != -1 { // -1表示唤醒操作
 		s, ok := l.handlers.Load(fd)
 		if ok {
-			s.(EventHandler).Handle(fd, events)
+			l.callHandler(s.(EventHandler), fd, events)
 		}
 	}
-	l.eventHandling.Set(false)
 	// 执行任务
-	l.asyncJobQueue.ExecuteJobs()
+	l.runJobs()
+}
+
+// awake eventloop正在处理事件，本轮结束前会执行队列里的job，不需要唤醒
+func (l *EventLoop) awake() {
+	if !l.wakePending.Get() {
+		l.wakePending.Set(true)
+	}
+}
+
+func (l *EventLoop) handleCompletion(userData uint64, res int32, flags uint32, buf []byte) {
+	l.awake()
+	if userData != limpoller.RingWake {
+		s, ok := l.handlers.Load(userData >> 8)
+		if ok {
+			l.callCompletionHandler(s.(CompletionHandler), Op(userData&0xff), res, limpoller.CQEMore(flags), buf)
+		}
+	}
+	// 执行任务
+	l.runJobs()
+}
+
+// callCompletionHandler 调用处理者，恢复处理者中的panic
+func (l *EventLoop) callCompletionHandler(h CompletionHandler, op Op, res int32, more bool, buf []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			l.panics.Add(1)
+			err := NewPanicError(r)
+			l.Warn("EventLoop处理handleCompletion遇到异常，请检查代码！", zap.Int("op", int(op)), zap.Error(err))
+			if eh, ok := h.(ErrorHandler); ok {
+				eh.HandleError(err)
+			} else if l.onError != nil {
+				l.onError(err)
+			}
+		}
+	}()
+	h.HandleCompletion(op, res, more, buf)
+}
+
+// callHandler 调用处理者，恢复处理者中的panic
+func (l *EventLoop) callHandler(h EventHandler, fd int, events limpoller.Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			l.panics.Add(1)
+			err := NewPanicError(r)
+			l.Warn("EventLoop处理handleEvent遇到异常，请检查代码！", zap.Int("fd", fd), zap.Error(err))
+			if eh, ok := h.(ErrorHandler); ok {
+				eh.HandleError(err)
+			} else if l.onError != nil {
+				l.onError(err)
+			}
+		}
+	}()
+	h.Handle(fd, events)
+}
+
+func (l *EventLoop) handleJobError(err error) {
+	if _, ok := err.(*PanicError); ok {
+		l.panics.Add(1)
+	}
+	if l.onError != nil {
+		l.onError(err)
+		return
+	}
+	l.Warn("执行job失败！", zap.Error(err))
 }
@@ -0,0 +1,29 @@
+package eventloop
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError 从panic中恢复的错误，包含panic的值和堆栈
+type PanicError struct {
+	Value interface{} // panic的值
+	Stack []byte      // panic时的堆栈
+}
+
+// NewPanicError 通过recover()的返回值创建PanicError（需要在recover所在的defer里调用才能拿到正确的堆栈）
+func NewPanicError(v interface{}) *PanicError {
+	return &PanicError{Value: v, Stack: debug.Stack()}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.Value, e.Stack)
+}
+
+// Unwrap 如果panic的值是error则返回它
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
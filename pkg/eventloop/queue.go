@@ -7,45 +7,145 @@ package eventloop
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/tangtaoit/limnet/pkg/limlog"
-	"github.com/tangtaoit/limnet/pkg/limutil"
 	"go.uber.org/zap"
 )
 
 // Job is a asynchronous function.
 type Job func() error
 
-// AsyncJobQueue queues pending tasks.
+// jobNode 队列节点，出队后回收到jobNodePool
+type jobNode struct {
+	next     unsafe.Pointer // *jobNode
+	job      Job
+	flusher  Flusher // job为nil时执行flusher.FlushWrites
+	pushedAt int64   // 入队时间（纳秒）
+}
+
+var jobNodePool = sync.Pool{New: func() interface{} { return new(jobNode) }}
+
+// AsyncJobQueue 无锁的多生产者单消费者队列（Vyukov MPSC），Push可以在任意goroutine调用，ExecuteJobs只能在eventloop里调用
 type AsyncJobQueue struct {
-	lock sync.Locker
-	jobs []func() error
+	length       int64          // 待执行的job数量
+	executed     int64          // 已执行的job数量
+	latencyNanos int64          // job从入队到执行的累计等待时间（纳秒）
+	head         unsafe.Pointer // 最后入队的节点 *jobNode
+	tail         *jobNode       // 已出队的节点（哨兵），tail.next为下一个要执行的job
 }
 
 // NewAsyncJobQueue creates a note-queue.
 func NewAsyncJobQueue() AsyncJobQueue {
-	return AsyncJobQueue{lock: &limutil.SpinLock{}}
+	stub := &jobNode{}
+	return AsyncJobQueue{head: unsafe.Pointer(stub), tail: stub}
 }
 
 // Push pushes a item into queue.
 func (q *AsyncJobQueue) Push(job Job) (jobsNum int) {
-	q.lock.Lock()
-	q.jobs = append(q.jobs, job)
-	jobsNum = len(q.jobs)
-	q.lock.Unlock()
+	return q.push(job, nil)
+}
+
+// PushFlusher 入队一个FlushWrites调用，不需要分配闭包
+func (q *AsyncJobQueue) PushFlusher(f Flusher) (jobsNum int) {
+	return q.push(nil, f)
+}
+
+func (q *AsyncJobQueue) push(job Job, f Flusher) (jobsNum int) {
+	n := jobNodePool.Get().(*jobNode)
+	n.job = job
+	n.flusher = f
+	n.pushedAt = time.Now().UnixNano()
+	jobsNum = int(atomic.AddInt64(&q.length, 1))
+	prev := (*jobNode)(atomic.SwapPointer(&q.head, unsafe.Pointer(n)))
+	atomic.StorePointer(&prev.next, unsafe.Pointer(n))
 	return
 }
 
-// ExecuteJobs 执行所有job
-func (q *AsyncJobQueue) ExecuteJobs() {
-	q.lock.Lock()
-	jobs := q.jobs
-	q.jobs = nil
-	q.lock.Unlock()
-	for i := range jobs {
-		if err := jobs[i](); err != nil {
-			limlog.Warn("执行job失败！", zap.Error(err))
+// pop 取出下一个job，队列为空（或者生产者还没链接上节点）时ok为false
+func (q *AsyncJobQueue) pop() (job Job, f Flusher, pushedAt int64, ok bool) {
+	next := (*jobNode)(atomic.LoadPointer(&q.tail.next))
+	if next == nil {
+		return
+	}
+	old := q.tail
+	q.tail = next // next成为新的哨兵
+	job, f, pushedAt = next.job, next.flusher, next.pushedAt
+	next.job, next.flusher = nil, nil
+	old.next = nil
+	jobNodePool.Put(old)
+	atomic.AddInt64(&q.length, -1)
+	return job, f, pushedAt, true
+}
+
+// ExecuteJobs 最多执行max个job（max<=0不限制），返回执行的数量 job返回的错误和panic交给onError处理，onError为nil则只打印日志
+func (q *AsyncJobQueue) ExecuteJobs(max int, onError func(err error)) int {
+	now := time.Now().UnixNano()
+	var latency int64
+	n := 0
+	for max <= 0 || n < max {
+		job, f, pushedAt, ok := q.pop()
+		if !ok {
+			break
+		}
+		n++
+		if pushedAt > now { // 执行期间入队的job
+			now = time.Now().UnixNano()
+		}
+		latency += now - pushedAt
+		var err error
+		if job != nil {
+			err = runJob(job)
+		} else {
+			err = runFlusher(f)
+		}
+		if err != nil {
+			if onError != nil {
+				onError(err)
+			} else {
+				limlog.Warn("执行job失败！", zap.Error(err))
+			}
+		}
+	}
+	if n > 0 {
+		atomic.AddInt64(&q.executed, int64(n))
+		atomic.AddInt64(&q.latencyNanos, latency)
+	}
+	return n
+}
+
+// runJob 执行job，job中的panic会转换为PanicError返回，不会影响后面的job
+func runJob(job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewPanicError(r)
 		}
+	}()
+	return job()
+}
+
+// runFlusher 执行FlushWrites，panic会转换为PanicError返回
+func runFlusher(f Flusher) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewPanicError(r)
+		}
+	}()
+	f.FlushWrites()
+	return nil
+}
+
+// Len 待执行的job数量
+func (q *AsyncJobQueue) Len() int {
+	if n := atomic.LoadInt64(&q.length); n > 0 {
+		return int(n)
 	}
-	return
+	return 0
+}
+
+// Executed 返回已执行的job数量和累计等待时间
+func (q *AsyncJobQueue) Executed() (count int64, latency time.Duration) {
+	return atomic.LoadInt64(&q.executed), time.Duration(atomic.LoadInt64(&q.latencyNanos))
 }
@@ -0,0 +1,229 @@
+package eventloop
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tangtaoit/limnet/pkg/limutil"
+)
+
+func TestAsyncJobQueue_MultiProducer(t *testing.T) {
+	const producers, perProducer = 8, 10000
+	q := NewAsyncJobQueue()
+	last := make([]int, producers) // 只在消费者里访问
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 1; i <= perProducer; i++ {
+				i := i
+				q.Push(func() error {
+					if last[p] != i-1 {
+						t.Errorf("producer %d: got %d after %d", p, i, last[p])
+					}
+					last[p] = i
+					return nil
+				})
+			}
+		}(p)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		q.ExecuteJobs(100, nil)
+	}
+	q.ExecuteJobs(0, nil)
+	for p, n := range last {
+		if n != perProducer {
+			t.Fatalf("producer %d: executed %d jobs, want %d", p, n, perProducer)
+		}
+	}
+	if q.Len() != 0 {
+		t.Fatalf("Len() = %d, want 0", q.Len())
+	}
+}
+
+// orderFlusher 记录FlushWrites的调用顺序
+type orderFlusher struct {
+	order *[]string
+	name  string
+}
+
+func (f *orderFlusher) FlushWrites() {
+	*f.order = append(*f.order, f.name)
+	if f.name == "panic" {
+		panic("flush")
+	}
+}
+
+func TestAsyncJobQueue_PushFlusher(t *testing.T) {
+	q := NewAsyncJobQueue()
+	var order []string
+	q.Push(func() error {
+		order = append(order, "job1")
+		return nil
+	})
+	q.PushFlusher(&orderFlusher{order: &order, name: "flush"})
+	q.PushFlusher(&orderFlusher{order: &order, name: "panic"})
+	q.Push(func() error {
+		order = append(order, "job2")
+		return nil
+	})
+	var errs []error
+	if n := q.ExecuteJobs(0, func(err error) { errs = append(errs, err) }); n != 4 {
+		t.Fatalf("ExecuteJobs() = %d, want 4", n)
+	}
+	// flusher和job按入队顺序执行，flusher的panic不影响后面的job
+	if got := strings.Join(order, ","); got != "job1,flush,panic,job2" {
+		t.Fatalf("order = %s", got)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expect 1 error but got %v", errs)
+	}
+	var pe *PanicError
+	if !errors.As(errs[0], &pe) {
+		t.Fatalf("expect PanicError but got %v", errs[0])
+	}
+
+	// 入队flusher不需要分配闭包
+	order = make([]string, 0, 200)
+	f := &orderFlusher{order: &order, name: "flush"}
+	allocs := testing.AllocsPerRun(100, func() {
+		q.PushFlusher(f)
+		q.ExecuteJobs(0, nil)
+	})
+	if allocs > 0 {
+		t.Fatalf("expect no allocs but got %v", allocs)
+	}
+}
+
+// spinJobQueue 原来的自旋锁slice队列，用于对比
+type spinJobQueue struct {
+	lock sync.Locker
+	jobs []queuedJob
+}
+
+type queuedJob struct {
+	job      Job
+	pushedAt int64
+}
+
+func (q *spinJobQueue) Push(job Job) {
+	q.lock.Lock()
+	q.jobs = append(q.jobs, queuedJob{job: job, pushedAt: time.Now().UnixNano()})
+	q.lock.Unlock()
+}
+
+func (q *spinJobQueue) ExecuteJobs() int {
+	q.lock.Lock()
+	jobs := q.jobs
+	q.jobs = nil
+	q.lock.Unlock()
+	for i := range jobs {
+		_ = runJob(jobs[i].job)
+	}
+	return len(jobs)
+}
+
+// benchmarkFanIn GOMAXPROCS个生产者同时Push，一个消费者不停执行
+func benchmarkFanIn(b *testing.B, push func(Job), execute func() int) {
+	var executed int64
+	job := func() error {
+		executed++
+		return nil
+	}
+	stop := make(chan struct{})
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+		for {
+			if execute() == 0 {
+				select {
+				case <-stop:
+					return
+				default:
+					runtime.Gosched()
+				}
+			}
+		}
+	}()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			push(job)
+		}
+	})
+	close(stop)
+	<-consumerDone
+	for execute() > 0 {
+	}
+	b.StopTimer()
+	if executed != int64(b.N) {
+		b.Fatalf("executed %d jobs, want %d", executed, b.N)
+	}
+}
+
+func BenchmarkJobQueue_FanIn(b *testing.B) {
+	b.Run("mpsc", func(b *testing.B) {
+		q := NewAsyncJobQueue()
+		benchmarkFanIn(b, func(job Job) { q.Push(job) }, func() int { return q.ExecuteJobs(maxJobsPerIteration, nil) })
+	})
+	b.Run("spinlock", func(b *testing.B) {
+		q := &spinJobQueue{lock: &limutil.SpinLock{}}
+		benchmarkFanIn(b, q.Push, q.ExecuteJobs)
+	})
+}
+
+// BenchmarkTrigger_FanIn 多个goroutine向运行中的eventloop Trigger，wakeups/op为eventloop被唤醒的次数
+func BenchmarkTrigger_FanIn(b *testing.B) {
+	run := func(b *testing.B, trigger func(l *EventLoop, job Job)) {
+		l, err := New()
+		if err != nil {
+			b.Fatal(err)
+		}
+		go l.Run()
+		defer l.Stop()
+		var executed int64
+		job := func() error {
+			atomic.AddInt64(&executed, 1)
+			return nil
+		}
+		start := l.Stats().Wakeups
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				trigger(l, job)
+			}
+		})
+		for atomic.LoadInt64(&executed) < int64(b.N) {
+			runtime.Gosched()
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(l.Stats().Wakeups-start)/float64(b.N), "wakeups/op")
+	}
+	b.Run("wake-pending", func(b *testing.B) {
+		run(b, func(l *EventLoop, job Job) { _ = l.Trigger(job) })
+	})
+	// 原来的实现在eventloop没有处理事件时每次Trigger都会写eventfd
+	b.Run("wake-every-trigger", func(b *testing.B) {
+		run(b, func(l *EventLoop, job Job) {
+			l.asyncJobQueue.Push(job)
+			_ = l.poller.Wake()
+		})
+	})
+}
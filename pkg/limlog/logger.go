@@ -74,6 +74,11 @@ func SetLevel(l zapcore.Level) {
 	atom.SetLevel(l)
 }
 
+// GetLevel 获取当前日志等级
+func GetLevel() zapcore.Level {
+	return atom.Level()
+}
+
 func newEncoderConfig() zapcore.EncoderConfig {
 	return zapcore.EncoderConfig{
 		// Keys can be anything except the empty string.
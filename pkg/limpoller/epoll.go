@@ -12,16 +12,19 @@ import (
 // Make the endianness of bytes compatible with more linux OSs under different processor-architectures,
 // according to http://man7.org/linux/man-pages/man2/eventfd.2.html.
 
-const readEvent = unix.EPOLLIN | unix.EPOLLPRI
+const readEvent = unix.EPOLLIN | unix.EPOLLPRI | unix.EPOLLRDHUP
 const writeEvent = unix.EPOLLOUT
 
 // Poller Epoll封装
 type Poller struct {
 	fd       int
 	eventFd  int
+	started  atomic.Bool // Poll已经开始或者没有运行就已经关闭
 	running  atomic.Bool
 	waitDone chan struct{}
+	wakeups  atomic.Int64 // poll被唤醒的次数
 	buf      []byte
+	hook     func() // 每轮事件处理完之后的回调
 }
 
 // Create 创建Poller
@@ -71,8 +74,18 @@ func (ep *Poller) wakeHandlerRead() {
 	}
 }
 
+// Wakeups poll被唤醒的次数
+func (ep *Poller) Wakeups() int64 {
+	return ep.wakeups.Get()
+}
+
 // Close 关闭 epoll
 func (ep *Poller) Close() (err error) {
+	if ep.started.CompareAndSwap(false, true) { // 还没有运行过，直接释放
+		_ = unix.Close(ep.fd)
+		_ = unix.Close(ep.eventFd)
+		return
+	}
 	if !ep.running.Get() {
 		return ErrClosed
 	}
@@ -132,8 +145,21 @@ func (ep *Poller) EnableRead(fd int) error {
 	return ep.mod(fd, readEvent)
 }
 
+// DisableReadWrite 取消fd注册的可读可写事件（fd仍在epoll中，错误事件仍会通知）
+func (ep *Poller) DisableReadWrite(fd int) error {
+	return ep.mod(fd, 0)
+}
+
+// SetIterationHook 设置每轮事件处理完之后的回调（需要在Poll之前设置）
+func (ep *Poller) SetIterationHook(hook func()) {
+	ep.hook = hook
+}
+
 // Poll 启动 epoll wait 循环
 func (ep *Poller) Poll(handler func(fd int, event Event)) {
+	if !ep.started.CompareAndSwap(false, true) { // 已经关闭
+		return
+	}
 	defer func() {
 		if err := recover(); err != nil {
 			limlog.Error("非常严重poller遇到异常退出去了，将有一批连接断开！！！，建议重启！（上层需要把异常消化调 Poller.Poll的handler方法建议加上defer）Poll Exit: ", zap.Error(err.(error)))
@@ -147,6 +173,7 @@ func (ep *Poller) Poll(handler func(fd int, event Event)) {
 	ep.running.Set(true)
 	for {
 		n, err := unix.EpollWait(ep.fd, events, -1)
+		ep.wakeups.Add(1)
 
 		if err != nil && err != unix.EINTR {
 			limlog.Error("EpollWait: ", zap.Error(err))
@@ -166,6 +193,9 @@ func (ep *Poller) Poll(handler func(fd int, event Event)) {
 				if events[i].Events&(unix.EPOLLIN|unix.EPOLLPRI|unix.EPOLLRDHUP) != 0 {
 					rEvents |= EventRead
 				}
+				if events[i].Events&unix.EPOLLRDHUP != 0 {
+					rEvents |= EventReadHup
+				}
 				handler(fd, rEvents)
 			} else {
 				ep.wakeHandlerRead()
@@ -175,6 +205,11 @@ func (ep *Poller) Poll(handler func(fd int, event Event)) {
 
 		if wake {
 			handler(-1, 0)
+		}
+		if ep.hook != nil {
+			ep.hook()
+		}
+		if wake {
 			wake = false
 			if !ep.running.Get() {
 				return
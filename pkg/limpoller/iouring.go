@@ -0,0 +1,551 @@
+// +build linux
+
+package limpoller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/tangtaoit/limnet/pkg/limlog"
+	latomic "github.com/tangtaoit/limnet/pkg/limutil/sync/atomic"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// io_uring 内核ABI常量（include/uapi/linux/io_uring.h）
+const (
+	ioringOffSQRing = 0
+	ioringOffSQEs   = 0x10000000
+
+	ioringSetupCQSize = 1 << 3
+
+	ioringFeatSingleMmap = 1 << 0
+	ioringFeatNoDrop     = 1 << 1
+
+	ioringEnterGetEvents = 1 << 0
+
+	ioringRegisterPbufRing = 22
+
+	ioringOpRead        = 22
+	ioringOpSend        = 26
+	ioringOpRecv        = 27
+	ioringOpAccept      = 13
+	ioringOpAsyncCancel = 14
+
+	iosqeIOLink       = 1 << 2
+	iosqeBufferSelect = 1 << 5
+
+	ioringAcceptMultishot = 1 << 0
+	ioringRecvMultishot   = 1 << 1
+
+	ioringCQEFBuffer     = 1 << 0
+	ioringCQEFMore       = 1 << 1
+	ioringCQEBufferShift = 16
+
+	ringBufGroup = 0
+)
+
+const (
+	// RingWake 唤醒事件的userData（Run会以此值调用handler，用于执行异步任务）
+	RingWake = ^uint64(0)
+	// ringIgnore 不需要通知的操作的userData（例如取消操作本身的完成事件）
+	ringIgnore = ^uint64(0) - 1
+)
+
+var (
+	// ErrRingUnsupported 当前系统不支持io_uring
+	ErrRingUnsupported = errors.New("当前系统不支持io_uring")
+	// ErrRingFull 提交队列已满
+	ErrRingFull = errors.New("io_uring提交队列已满")
+)
+
+type sqringOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type cqringOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type ringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  sqringOffsets
+	cqOff                                                                  cqringOffsets
+}
+
+// sqe io_uring_sqe（64字节）
+type sqe struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+// cqe io_uring_cqe（16字节）
+type cqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// ringBuf io_uring_buf 提供给内核的buffer（buffer ring的元素），第0个元素的resv字段为ring的tail
+type ringBuf struct {
+	addr uint64
+	len  uint32
+	bid  uint16
+	resv uint16
+}
+
+type bufReg struct {
+	ringAddr    uint64
+	ringEntries uint32
+	bgid        uint16
+	flags       uint16
+	resv        [3]uint64
+}
+
+// Ring io_uring封装（完成模型）
+// 读使用multishot recv和provided buffer ring，连接使用multishot accept，写使用链接的send保证顺序
+// 除Wake和Close外的方法都必须在Run所在的goroutine里调用（或者在Run之前调用）
+type Ring struct {
+	fd       int
+	eventFd  int
+	started  latomic.Bool // Run已经开始或者没有运行就已经关闭
+	running  latomic.Bool
+	waitDone chan struct{}
+	wakeups  latomic.Int64 // Run被唤醒的次数
+	hook     func()        // 每轮完成事件处理完之后的回调
+
+	ringMem   []byte
+	sqesMem   []byte
+	sqHead    *uint32
+	sqTail    *uint32
+	sqMask    uint32
+	sqEntries uint32
+	sqes      []sqe
+	sqeTail   uint32 // 本地的tail，提交时同步给内核
+	cqHead    *uint32
+	cqTail    *uint32
+	cqMask    uint32
+	cqes      []cqe
+
+	bufRingMem []byte
+	bufs       []ringBuf
+	bufMask    uint16
+	bufTail    uint16
+	bufSize    int
+	bufData    []byte // 所有provided buffer的内存
+
+	wakeBuf []byte // eventfd的读缓存
+}
+
+// NewRing 创建io_uring entries为提交队列大小，bufCount（必须是2的幂）和bufSize为读使用的buffer数量和大小
+func NewRing(entries uint32, bufCount int, bufSize int) (*Ring, error) {
+	if bufCount <= 0 || bufCount&(bufCount-1) != 0 || bufCount > 1<<15 {
+		return nil, errors.New("bufCount必须是2的幂且不大于32768")
+	}
+	p := ringParams{flags: ioringSetupCQSize, cqEntries: entries * 4}
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	r := &Ring{fd: int(fd), eventFd: -1, waitDone: make(chan struct{}), bufSize: bufSize, wakeBuf: make([]byte, 8)}
+	if err := r.init(&p, bufCount); err != nil {
+		r.release()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Ring) init(p *ringParams, bufCount int) error {
+	if p.features&ioringFeatSingleMmap == 0 || p.features&ioringFeatNoDrop == 0 {
+		return ErrRingUnsupported
+	}
+	var err error
+	size := p.sqOff.array + p.sqEntries*4
+	if cqSize := p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(cqe{})); cqSize > size {
+		size = cqSize
+	}
+	if r.ringMem, err = unix.Mmap(r.fd, ioringOffSQRing, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		return err
+	}
+	if r.sqesMem, err = unix.Mmap(r.fd, ioringOffSQEs, int(p.sqEntries)*int(unsafe.Sizeof(sqe{})), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		return err
+	}
+	base := unsafe.Pointer(&r.ringMem[0])
+	r.sqHead = (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.sqOff.head)))
+	r.sqTail = (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.sqOff.tail)))
+	r.sqMask = *(*uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.sqOff.ringMask)))
+	r.sqEntries = p.sqEntries
+	r.sqeTail = atomic.LoadUint32(r.sqTail)
+	// sq的array固定为和sqes一一对应
+	array := (*[1 << 28]uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.sqOff.array)))[:p.sqEntries:p.sqEntries]
+	for i := range array {
+		array[i] = uint32(i)
+	}
+	r.sqes = (*[1 << 24]sqe)(unsafe.Pointer(&r.sqesMem[0]))[:p.sqEntries:p.sqEntries]
+	r.cqHead = (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.cqOff.head)))
+	r.cqTail = (*uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.cqOff.tail)))
+	r.cqMask = *(*uint32)(unsafe.Pointer(uintptr(base) + uintptr(p.cqOff.ringMask)))
+	r.cqes = (*[1 << 24]cqe)(unsafe.Pointer(uintptr(base) + uintptr(p.cqOff.cqes)))[:p.cqEntries:p.cqEntries]
+
+	// provided buffer ring（内存需要页对齐，所以使用mmap）
+	if r.bufRingMem, err = unix.Mmap(-1, 0, bufCount*int(unsafe.Sizeof(ringBuf{})), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE); err != nil {
+		return err
+	}
+	r.bufs = (*[1 << 15]ringBuf)(unsafe.Pointer(&r.bufRingMem[0]))[:bufCount:bufCount]
+	r.bufMask = uint16(bufCount - 1)
+	reg := bufReg{ringAddr: uint64(uintptr(unsafe.Pointer(&r.bufRingMem[0]))), ringEntries: uint32(bufCount), bgid: ringBufGroup}
+	if _, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(r.fd), ioringRegisterPbufRing, uintptr(unsafe.Pointer(&reg)), 1, 0, 0); errno != 0 {
+		return errno
+	}
+	r.bufData = make([]byte, bufCount*r.bufSize)
+	for i := 0; i < bufCount; i++ {
+		r.addBuffer(uint16(i))
+	}
+	r.publishBuffers()
+
+	// 唤醒使用eventfd
+	efd, _, errno := unix.Syscall(unix.SYS_EVENTFD2, 0, unix.O_CLOEXEC, 0)
+	if errno != 0 {
+		return errno
+	}
+	r.eventFd = int(efd)
+	return r.readWake()
+}
+
+// release 释放资源 fd不重置，关闭后其他goroutine的Wake可能还会读取eventFd（和epoll一样返回错误）
+func (r *Ring) release() {
+	if r.fd >= 0 {
+		_ = unix.Close(r.fd)
+	}
+	if r.eventFd >= 0 {
+		_ = unix.Close(r.eventFd)
+	}
+	for _, mem := range [][]byte{r.ringMem, r.sqesMem, r.bufRingMem} {
+		if mem != nil {
+			_ = unix.Munmap(mem)
+		}
+	}
+	r.ringMem, r.sqesMem, r.bufRingMem = nil, nil, nil
+}
+
+// addBuffer 将buffer放回buffer ring（publishBuffers后内核可见）
+func (r *Ring) addBuffer(bid uint16) {
+	b := &r.bufs[r.bufTail&r.bufMask]
+	b.addr = uint64(uintptr(unsafe.Pointer(&r.bufData[int(bid)*r.bufSize])))
+	b.len = uint32(r.bufSize)
+	b.bid = bid
+	r.bufTail++
+}
+
+// publishBuffers 更新buffer ring的tail tail是第0个元素的resv字段（16位），Go没有16位的原子操作，所以和bid一起按32位写入
+func (r *Ring) publishBuffers() {
+	word := (*uint32)(unsafe.Pointer(&r.bufs[0].bid))
+	if littleEndian {
+		atomic.StoreUint32(word, uint32(r.bufs[0].bid)|uint32(r.bufTail)<<16)
+	} else {
+		atomic.StoreUint32(word, uint32(r.bufs[0].bid)<<16|uint32(r.bufTail))
+	}
+}
+
+var littleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// reserve 确保提交队列里有n个空位，空间不足时先提交已有的sqe
+func (r *Ring) reserve(n int) error {
+	if uint32(n) > r.sqEntries {
+		return ErrRingFull
+	}
+	if r.sqeTail-atomic.LoadUint32(r.sqHead)+uint32(n) <= r.sqEntries {
+		return nil
+	}
+	if err := r.enter(0, 0); err != nil {
+		return err
+	}
+	if r.sqeTail-atomic.LoadUint32(r.sqHead)+uint32(n) > r.sqEntries {
+		return ErrRingFull
+	}
+	return nil
+}
+
+// nextSQE 获取下一个sqe（需要先reserve）
+func (r *Ring) nextSQE() *sqe {
+	s := &r.sqes[r.sqeTail&r.sqMask]
+	*s = sqe{}
+	r.sqeTail++
+	return s
+}
+
+func (r *Ring) getSQE() (*sqe, error) {
+	if err := r.reserve(1); err != nil {
+		return nil, err
+	}
+	return r.nextSQE(), nil
+}
+
+// enter 提交sqe并等待至少minComplete个完成事件
+func (r *Ring) enter(minComplete uint32, flags uint32) error {
+	atomic.StoreUint32(r.sqTail, r.sqeTail)
+	toSubmit := r.sqeTail - atomic.LoadUint32(r.sqHead)
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(toSubmit), uintptr(minComplete), uintptr(flags), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (r *Ring) readWake() error {
+	s, err := r.getSQE()
+	if err != nil {
+		return err
+	}
+	s.opcode = ioringOpRead
+	s.fd = int32(r.eventFd)
+	s.addr = uint64(uintptr(unsafe.Pointer(&r.wakeBuf[0])))
+	s.len = uint32(len(r.wakeBuf))
+	s.off = ^uint64(0)
+	s.userData = RingWake
+	return nil
+}
+
+// Accept 提交multishot accept 接受的连接为非阻塞的，完成事件的res为连接的fd
+func (r *Ring) Accept(fd int, userData uint64) error {
+	s, err := r.getSQE()
+	if err != nil {
+		return err
+	}
+	s.opcode = ioringOpAccept
+	s.fd = int32(fd)
+	s.ioprio = ioringAcceptMultishot
+	s.opFlags = unix.SOCK_NONBLOCK | unix.SOCK_CLOEXEC
+	s.userData = userData
+	return nil
+}
+
+// Recv 提交multishot recv 数据读到provided buffer里，通过Run的handler的buf参数返回
+func (r *Ring) Recv(fd int, userData uint64) error {
+	s, err := r.getSQE()
+	if err != nil {
+		return err
+	}
+	s.opcode = ioringOpRecv
+	s.fd = int32(fd)
+	s.ioprio = ioringRecvMultishot
+	s.flags = iosqeBufferSelect
+	s.bufIndex = ringBufGroup
+	s.userData = userData
+	return nil
+}
+
+// Send 提交send 多个buffer使用IOSQE_IO_LINK按顺序发送，每个buffer都会产生一个完成事件
+// 使用MSG_WAITALL，部分发送会打断链接，后面的send以-ECANCELED完成，调用者需要重新提交剩余的数据
+// 完成之前调用者必须持有bufs的引用并且不能修改bufs
+func (r *Ring) Send(fd int, bufs [][]byte, userData uint64) error {
+	if err := r.reserve(len(bufs)); err != nil {
+		return err
+	}
+	for i := range bufs {
+		s := r.nextSQE()
+		s.opcode = ioringOpSend
+		s.fd = int32(fd)
+		s.addr = uint64(uintptr(unsafe.Pointer(&bufs[i][0])))
+		s.len = uint32(len(bufs[i]))
+		s.opFlags = unix.MSG_WAITALL | unix.MSG_NOSIGNAL
+		if i < len(bufs)-1 {
+			s.flags = iosqeIOLink
+		}
+		s.userData = userData
+	}
+	return nil
+}
+
+// Cancel 取消userData对应的操作（被取消的操作以-ECANCELED完成）
+func (r *Ring) Cancel(userData uint64) error {
+	s, err := r.getSQE()
+	if err != nil {
+		return err
+	}
+	s.opcode = ioringOpAsyncCancel
+	s.fd = -1
+	s.addr = userData
+	s.userData = ringIgnore
+	return nil
+}
+
+// Wake 唤醒Run
+func (r *Ring) Wake() error {
+	_, err := unix.Write(r.eventFd, wakeBytes)
+	return err
+}
+
+// Wakeups Run被唤醒的次数
+func (r *Ring) Wakeups() int64 {
+	return r.wakeups.Get()
+}
+
+// Close 关闭io_uring
+func (r *Ring) Close() (err error) {
+	if r.started.CompareAndSwap(false, true) { // 还没有运行过，直接释放
+		r.release()
+		return
+	}
+	if !r.running.Get() {
+		return ErrClosed
+	}
+
+	r.running.Set(false)
+	if err = r.Wake(); err != nil {
+		return
+	}
+
+	<-r.waitDone
+	r.release()
+	return
+}
+
+// SetIterationHook 设置每轮完成事件处理完之后的回调（需要在Run之前设置）
+func (r *Ring) SetIterationHook(hook func()) {
+	r.hook = hook
+}
+
+// Run 提交操作并处理完成事件 handler的buf只在handler执行期间有效
+func (r *Ring) Run(handler func(userData uint64, res int32, flags uint32, buf []byte)) {
+	if !r.started.CompareAndSwap(false, true) { // 已经关闭
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			limlog.Error("非常严重io_uring遇到异常退出去了，将有一批连接断开！！！，建议重启！Run Exit: ", zap.Any("err", err))
+		}
+		limlog.Error("Ring的Run方法退出！！！")
+		close(r.waitDone)
+	}()
+
+	r.running.Set(true)
+	for {
+		err := r.enter(1, ioringEnterGetEvents)
+		r.wakeups.Add(1)
+		if err != nil && err != unix.EINTR && err != unix.EAGAIN && err != unix.EBUSY {
+			limlog.Error("io_uring_enter: ", zap.Error(err))
+			continue
+		}
+
+		var wake bool
+		head := atomic.LoadUint32(r.cqHead)
+		tail := atomic.LoadUint32(r.cqTail)
+		for ; head != tail; head++ {
+			c := r.cqes[head&r.cqMask]
+			atomic.StoreUint32(r.cqHead, head+1)
+			switch c.userData {
+			case RingWake:
+				wake = true
+				if err = r.readWake(); err != nil {
+					limlog.Error("readWake: ", zap.Error(err))
+				}
+				continue
+			case ringIgnore:
+				continue
+			}
+			if c.flags&ioringCQEFBuffer != 0 {
+				bid := uint16(c.flags >> ioringCQEBufferShift)
+				var buf []byte
+				if c.res > 0 {
+					offset := int(bid) * r.bufSize
+					buf = r.bufData[offset : offset+int(c.res)]
+				}
+				handler(c.userData, c.res, c.flags, buf)
+				r.addBuffer(bid)
+				r.publishBuffers()
+				continue
+			}
+			handler(c.userData, c.res, c.flags, nil)
+		}
+
+		if wake {
+			handler(RingWake, 0, 0, nil)
+		}
+		if r.hook != nil {
+			r.hook()
+		}
+		if wake && !r.running.Get() {
+			return
+		}
+	}
+}
+
+// CQEMore 完成事件的flags是否表示multishot操作还会继续产生完成事件
+func CQEMore(flags uint32) bool {
+	return flags&ioringCQEFMore != 0
+}
+
+var (
+	ringAvailableOnce sync.Once
+	ringAvailable     bool
+)
+
+// IOURingAvailable 当前系统是否可以使用io_uring（需要内核6.0+支持multishot recv和provided buffer ring）
+func IOURingAvailable() bool {
+	ringAvailableOnce.Do(func() {
+		if !kernelAtLeast(6, 0) {
+			return
+		}
+		r, err := NewRing(8, 8, 64)
+		if err != nil {
+			limlog.Debug("io_uring不可用", zap.Error(err))
+			return
+		}
+		r.release()
+		ringAvailable = true
+	})
+	return ringAvailable
+}
+
+func kernelAtLeast(major, minor int) bool {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return false
+	}
+	release := string(uts.Release[:])
+	if i := strings.IndexByte(release, 0); i >= 0 {
+		release = release[:i]
+	}
+	parts := strings.SplitN(release, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	maj, err1 := strconv.Atoi(parts[0])
+	min, err2 := strconv.Atoi(leadingDigits(parts[1]))
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return maj > major || (maj == major && min >= minor)
+}
+
+func leadingDigits(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return s[:i]
+		}
+	}
+	return s
+}
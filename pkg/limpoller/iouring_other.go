@@ -0,0 +1,56 @@
+// +build !linux
+
+package limpoller
+
+import "errors"
+
+// RingWake 唤醒事件的userData
+const RingWake = ^uint64(0)
+
+var (
+	// ErrRingUnsupported 当前系统不支持io_uring
+	ErrRingUnsupported = errors.New("当前系统不支持io_uring")
+	// ErrRingFull 提交队列已满
+	ErrRingFull = errors.New("io_uring提交队列已满")
+)
+
+// Ring io_uring只支持linux，其他系统总是不可用
+type Ring struct{}
+
+// NewRing 其他系统总是返回ErrRingUnsupported
+func NewRing(entries uint32, bufCount int, bufSize int) (*Ring, error) {
+	return nil, ErrRingUnsupported
+}
+
+// IOURingAvailable 其他系统总是返回false
+func IOURingAvailable() bool { return false }
+
+// CQEMore 完成事件的flags是否表示multishot操作还会继续产生完成事件
+func CQEMore(flags uint32) bool { return false }
+
+// Accept 不支持
+func (r *Ring) Accept(fd int, userData uint64) error { return ErrRingUnsupported }
+
+// Recv 不支持
+func (r *Ring) Recv(fd int, userData uint64) error { return ErrRingUnsupported }
+
+// Send 不支持
+func (r *Ring) Send(fd int, bufs [][]byte, userData uint64) error { return ErrRingUnsupported }
+
+// Cancel 不支持
+func (r *Ring) Cancel(userData uint64) error { return ErrRingUnsupported }
+
+// Wake 不支持
+func (r *Ring) Wake() error { return ErrRingUnsupported }
+
+// Wakeups 不支持
+func (r *Ring) Wakeups() int64 { return 0 }
+
+// Close 不支持
+func (r *Ring) Close() error { return ErrRingUnsupported }
+
+// SetIterationHook 不支持
+func (r *Ring) SetIterationHook(hook func()) {}
+
+// Run 不支持
+func (r *Ring) Run(handler func(userData uint64, res int32, flags uint32, buf []byte)) {}
@@ -0,0 +1,73 @@
+// +build linux
+
+package limpoller
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRing_CloseBeforeRun(t *testing.T) {
+	if !IOURingAvailable() {
+		t.Skip("io_uring不可用")
+	}
+	r, err := NewRing(64, 8, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有运行过的ring直接释放fd和mmap
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = unix.FcntlInt(uintptr(r.fd), unix.F_GETFD, 0); err != unix.EBADF {
+		t.Fatalf("expect fd closed but got %v", err)
+	}
+	if r.ringMem != nil {
+		t.Fatal("expect ring memory unmapped")
+	}
+	if err = r.Close(); err == nil {
+		t.Fatal("ring should be closed")
+	}
+	r.Run(func(userData uint64, res int32, flags uint32, buf []byte) {}) // 关闭后不再运行，直接返回
+}
+
+func TestRing_Recv(t *testing.T) {
+	if !IOURingAvailable() {
+		t.Skip("io_uring不可用")
+	}
+	r, err := NewRing(64, 8, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+	if err = r.Recv(fds[0], 1); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+	go r.Run(func(userData uint64, res int32, flags uint32, buf []byte) {
+		if userData == 1 && res > 0 {
+			received <- string(buf)
+		}
+	})
+	if _, err = unix.Write(fds[1], []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-received:
+		if data != "hello" {
+			t.Fatalf("收到的数据错误：%q", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("没有收到数据")
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
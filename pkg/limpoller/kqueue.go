@@ -15,9 +15,12 @@ import (
 // Poller Kqueue封装
 type Poller struct {
 	fd       int
+	started  atomic.Bool // Poll已经开始或者没有运行就已经关闭
 	running  atomic.Bool
 	waitDone chan struct{}
-	sockets  sync.Map // [fd]events
+	wakeups  atomic.Int64 // poll被唤醒的次数
+	sockets  sync.Map     // [fd]events
+	hook     func()       // 每轮事件处理完之后的回调
 }
 
 // Create 创建Poller
@@ -51,8 +54,17 @@ func (p *Poller) Wake() error {
 	return err
 }
 
+// Wakeups poll被唤醒的次数
+func (p *Poller) Wakeups() int64 {
+	return p.wakeups.Get()
+}
+
 // Close 关闭 kqueue
 func (p *Poller) Close() (err error) {
+	if p.started.CompareAndSwap(false, true) { // 还没有运行过，直接释放
+		_ = unix.Close(p.fd)
+		return
+	}
 	if !p.running.Get() {
 		return ErrClosed
 	}
@@ -139,6 +151,21 @@ func (p *Poller) EnableWrite(fd int) error {
 	return err
 }
 
+// DisableReadWrite 取消fd注册的可读可写事件
+func (p *Poller) DisableReadWrite(fd int) error {
+	oldEvents, ok := p.sockets.Load(fd)
+	if !ok {
+		return errors.New("sync map load error")
+	}
+
+	kEvents := p.kEvents(oldEvents.(Event), EventNone, fd)
+	_, err := unix.Kevent(p.fd, kEvents, nil, nil)
+	if err == nil {
+		p.sockets.Store(fd, EventNone)
+	}
+	return err
+}
+
 func (p *Poller) kEvents(old Event, new Event, fd int) (ret []unix.Kevent_t) {
 	if new&EventRead != 0 {
 		if old&EventRead == 0 {
@@ -162,8 +189,16 @@ func (p *Poller) kEvents(old Event, new Event, fd int) (ret []unix.Kevent_t) {
 	return
 }
 
+// SetIterationHook 设置每轮事件处理完之后的回调（需要在Poll之前设置）
+func (p *Poller) SetIterationHook(hook func()) {
+	p.hook = hook
+}
+
 // Poll 启动 kqueue 循环
 func (p *Poller) Poll(handler func(fd int, event Event)) {
+	if !p.started.CompareAndSwap(false, true) { // 已经关闭
+		return
+	}
 	defer func() {
 		if err := recover(); err != nil {
 			limlog.Error("Poll Exit: ", zap.Error(err.(error)))
@@ -176,6 +211,7 @@ func (p *Poller) Poll(handler func(fd int, event Event)) {
 	p.running.Set(true)
 	for {
 		n, err := unix.Kevent(p.fd, nil, events, nil)
+		p.wakeups.Add(1)
 		if err != nil && err != unix.EINTR {
 			limlog.Error("EpollWait: ", zap.Error(err))
 			continue
@@ -185,14 +221,20 @@ func (p *Poller) Poll(handler func(fd int, event Event)) {
 			fd := int(events[i].Ident)
 			if fd != 0 {
 				var rEvents Event
-				if (events[i].Flags&unix.EV_ERROR != 0) || (events[i].Flags&unix.EV_EOF != 0) {
+				if events[i].Flags&unix.EV_ERROR != 0 {
 					rEvents |= EventErr
 				}
 				if events[i].Filter == unix.EVFILT_WRITE {
 					rEvents |= EventWrite
+					if events[i].Flags&unix.EV_EOF != 0 { // 连接已断开，不能再写
+						rEvents |= EventErr
+					}
 				}
 				if events[i].Filter == unix.EVFILT_READ {
 					rEvents |= EventRead
+					if events[i].Flags&unix.EV_EOF != 0 { // 对端关闭了写方向，可能还有数据没读
+						rEvents |= EventReadHup
+					}
 				}
 
 				handler(fd, rEvents)
@@ -203,6 +245,11 @@ func (p *Poller) Poll(handler func(fd int, event Event)) {
 
 		if wake {
 			handler(-1, 0)
+		}
+		if p.hook != nil {
+			p.hook()
+		}
+		if wake {
 			wake = false
 			if !p.running.Get() {
 				return
@@ -14,6 +14,8 @@ type Event uint32
 const (
 	EventRead  Event = 0x1
 	EventWrite Event = 0x2
-	EventErr   Event = 0x80
-	EventNone  Event = 0
+	// EventReadHup 对端关闭了写方向（半关闭），和EventRead一起返回，读完剩余的数据后会读到EOF
+	EventReadHup Event = 0x4
+	EventErr     Event = 0x80
+	EventNone    Event = 0
 )
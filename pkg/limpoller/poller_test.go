@@ -3,6 +3,8 @@ package limpoller
 import (
 	"testing"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 func TestPoller_Poll(t *testing.T) {
@@ -26,7 +28,15 @@ func TestPoller_Close(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// 没有运行过的poller直接释放fd
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = unix.FcntlInt(uintptr(s.fd), unix.F_GETFD, 0); err != unix.EBADF {
+		t.Fatalf("expect fd closed but got %v", err)
+	}
 	if err = s.Close(); err == nil {
 		t.Fatal("poller should be closed")
 	}
+	s.Poll(func(fd int, event Event) {}) // 关闭后不再运行，直接返回
 }
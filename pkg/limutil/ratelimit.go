@@ -0,0 +1,63 @@
+package limutil
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket 令牌桶限流器（并发安全）
+type TokenBucket struct {
+	lock   sync.Mutex
+	rate   float64   // 每秒产生的令牌数
+	burst  float64   // 桶容量
+	tokens float64   // 当前令牌数
+	last   time.Time // 上次补充令牌的时间
+}
+
+// NewTokenBucket 创建令牌桶 rate为每秒产生的令牌数 burst为桶容量（小于1按1处理）
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow 获取一个令牌，获取成功返回true
+func (b *TokenBucket) Allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+// Wait 返回距离下一个可用令牌需要等待的时长（不消耗令牌），为0表示当前有可用令牌
+func (b *TokenBucket) Wait() time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	b.last = now
+	b.tokens += elapsed * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
@@ -0,0 +1,25 @@
+package limutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Allow(t *testing.T) {
+	b := NewTokenBucket(10, 3)
+	for i := 0; i < 3; i++ {
+		if !b.Allow() {
+			t.Fatalf("expect token %d to be allowed", i)
+		}
+	}
+	if b.Allow() {
+		t.Fatal("expect bucket to be empty")
+	}
+	if wait := b.Wait(); wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("expect wait in (0,100ms] but got %s", wait)
+	}
+	time.Sleep(120 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("expect token to be refilled")
+	}
+}
@@ -60,3 +60,15 @@ func (a *Bool) Set(b bool) {
 func (a *Bool) Get() bool {
 	return atomic.LoadInt32(&a.b) == 1
 }
+
+// CompareAndSwap 值为old时设置为new，返回是否设置成功
+func (a *Bool) CompareAndSwap(old, new bool) bool {
+	var o, n int32
+	if old {
+		o = 1
+	}
+	if new {
+		n = 1
+	}
+	return atomic.CompareAndSwapInt32(&a.b, o, n)
+}
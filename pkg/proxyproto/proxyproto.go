@@ -0,0 +1,223 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrIncomplete 数据不足，需要等待更多数据
+	ErrIncomplete = errors.New("proxyproto: incomplete header")
+	// ErrNotProxy 数据不是以PROXY协议头开始
+	ErrNotProxy = errors.New("proxyproto: not a proxy protocol header")
+	// ErrInvalid PROXY协议头格式错误
+	ErrInvalid = errors.New("proxyproto: invalid header")
+)
+
+const v1MaxLen = 107 // v1头的最大长度（包含\r\n）
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// Command PROXY协议命令
+type Command byte
+
+const (
+	// CommandLocal 代理自身发起的连接（例如健康检查），地址信息应被忽略
+	CommandLocal Command = 0x0
+	// CommandProxy 代理转发的连接
+	CommandProxy Command = 0x1
+)
+
+// TLV v2头的扩展字段
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// 常用的TLV类型
+const (
+	TLVTypeALPN      byte = 0x01
+	TLVTypeAuthority byte = 0x02
+	TLVTypeCRC32C    byte = 0x03
+	TLVTypeNoop      byte = 0x04
+	TLVTypeUniqueID  byte = 0x05
+	TLVTypeSSL       byte = 0x20
+	TLVTypeNetNS     byte = 0x30
+	TLVTypeAWS       byte = 0xEA // AWS NLB的VPC endpoint id
+)
+
+// Header PROXY协议头
+type Header struct {
+	Version int     // 1或2
+	Command Command // v1总是CommandProxy
+	SrcAddr net.Addr
+	DstAddr net.Addr
+	TLVs    []TLV
+}
+
+// TLV 获取指定类型的TLV值
+func (h *Header) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Parse 从buf开头解析PROXY协议头（v1或v2），返回头和头占用的字节数
+// 数据不足返回ErrIncomplete，buf不是以PROXY协议头开始返回ErrNotProxy
+func Parse(buf []byte) (*Header, int, error) {
+	if hasPrefix(buf, v2Signature) {
+		if len(buf) < len(v2Signature) {
+			return nil, 0, ErrIncomplete
+		}
+		return parseV2(buf)
+	}
+	if hasPrefix(buf, v1Prefix) {
+		if len(buf) < len(v1Prefix) {
+			return nil, 0, ErrIncomplete
+		}
+		return parseV1(buf)
+	}
+	return nil, 0, ErrNotProxy
+}
+
+// hasPrefix buf是否以prefix开始（buf比prefix短时比较buf的全部内容）
+func hasPrefix(buf, prefix []byte) bool {
+	if len(buf) < len(prefix) {
+		return bytes.Equal(buf, prefix[:len(buf)])
+	}
+	return bytes.Equal(buf[:len(prefix)], prefix)
+}
+
+// parseV1 PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func parseV1(buf []byte) (*Header, int, error) {
+	end := bytes.Index(buf, []byte("\r\n"))
+	if end < 0 {
+		if len(buf) >= v1MaxLen {
+			return nil, 0, ErrInvalid
+		}
+		return nil, 0, ErrIncomplete
+	}
+	if end+2 > v1MaxLen {
+		return nil, 0, ErrInvalid
+	}
+	fields := strings.Split(string(buf[:end]), " ")
+	h := &Header{Version: 1, Command: CommandProxy}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, end + 2, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, 0, ErrInvalid
+	}
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || err1 != nil || err2 != nil {
+		return nil, 0, ErrInvalid
+	}
+	if (fields[1] == "TCP4") != (srcIP.To4() != nil) {
+		return nil, 0, ErrInvalid
+	}
+	h.SrcAddr = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+	h.DstAddr = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+	return h, end + 2, nil
+}
+
+func parseV2(buf []byte) (*Header, int, error) {
+	const headerLen = 16
+	if len(buf) < headerLen {
+		return nil, 0, ErrIncomplete
+	}
+	verCmd, fam := buf[12], buf[13]
+	if verCmd>>4 != 2 {
+		return nil, 0, ErrInvalid
+	}
+	cmd := Command(verCmd & 0x0F)
+	if cmd != CommandLocal && cmd != CommandProxy {
+		return nil, 0, ErrInvalid
+	}
+	total := headerLen + int(binary.BigEndian.Uint16(buf[14:16]))
+	if len(buf) < total {
+		return nil, 0, ErrIncomplete
+	}
+	h := &Header{Version: 2, Command: cmd}
+	payload := buf[headerLen:total]
+
+	var addrLen int
+	switch fam >> 4 {
+	case 0x0: // UNSPEC
+	case 0x1: // INET
+		addrLen = 12
+		if len(payload) < addrLen {
+			return nil, 0, ErrInvalid
+		}
+		h.SrcAddr, h.DstAddr = v2Addrs(payload, net.IPv4len, fam&0x0F)
+	case 0x2: // INET6
+		addrLen = 36
+		if len(payload) < addrLen {
+			return nil, 0, ErrInvalid
+		}
+		h.SrcAddr, h.DstAddr = v2Addrs(payload, net.IPv6len, fam&0x0F)
+	case 0x3: // UNIX
+		addrLen = 216
+		if len(payload) < addrLen {
+			return nil, 0, ErrInvalid
+		}
+		h.SrcAddr = &net.UnixAddr{Name: cString(payload[:108]), Net: "unix"}
+		h.DstAddr = &net.UnixAddr{Name: cString(payload[108:216]), Net: "unix"}
+	default:
+		return nil, 0, ErrInvalid
+	}
+	tlvs, err := parseTLVs(payload[addrLen:])
+	if err != nil {
+		return nil, 0, err
+	}
+	h.TLVs = tlvs
+	if cmd == CommandLocal {
+		h.SrcAddr, h.DstAddr = nil, nil
+	}
+	return h, total, nil
+}
+
+func v2Addrs(payload []byte, ipLen int, transport byte) (src, dst net.Addr) {
+	srcIP := net.IP(append([]byte(nil), payload[:ipLen]...))
+	dstIP := net.IP(append([]byte(nil), payload[ipLen:2*ipLen]...))
+	srcPort := int(binary.BigEndian.Uint16(payload[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(payload[2*ipLen+2:]))
+	if transport == 0x2 { // DGRAM
+		return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+	}
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+}
+
+func parseTLVs(buf []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(buf) > 0 {
+		if len(buf) < 3 {
+			return nil, ErrInvalid
+		}
+		l := int(binary.BigEndian.Uint16(buf[1:3]))
+		if len(buf) < 3+l {
+			return nil, ErrInvalid
+		}
+		tlvs = append(tlvs, TLV{Type: buf[0], Value: append([]byte(nil), buf[3:3+l]...)})
+		buf = buf[3+l:]
+	}
+	return tlvs, nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
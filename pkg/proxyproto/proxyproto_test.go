@@ -0,0 +1,109 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestParse_V1(t *testing.T) {
+	data := []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nhello")
+	h, n, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[n:]) != "hello" {
+		t.Fatalf("expect rest hello but got %q", data[n:])
+	}
+	if h.Version != 1 || h.SrcAddr.String() != "192.168.0.1:56324" || h.DstAddr.String() != "192.168.0.11:443" {
+		t.Fatalf("unexpected header %+v", h)
+	}
+
+	h, _, err = Parse([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n"))
+	if err != nil || h.SrcAddr.String() != "[2001:db8::1]:1" {
+		t.Fatalf("unexpected v6 header %+v %v", h, err)
+	}
+
+	h, _, err = Parse([]byte("PROXY UNKNOWN\r\n"))
+	if err != nil || h.SrcAddr != nil {
+		t.Fatalf("unexpected unknown header %+v %v", h, err)
+	}
+}
+
+func TestParse_V1Errors(t *testing.T) {
+	if _, _, err := Parse([]byte("PROX")); err != ErrIncomplete {
+		t.Fatalf("expect ErrIncomplete but got %v", err)
+	}
+	if _, _, err := Parse([]byte("PROXY TCP4 1.2.3.4")); err != ErrIncomplete {
+		t.Fatalf("expect ErrIncomplete but got %v", err)
+	}
+	if _, _, err := Parse([]byte("GET / HTTP/1.1\r\n")); err != ErrNotProxy {
+		t.Fatalf("expect ErrNotProxy but got %v", err)
+	}
+	if _, _, err := Parse([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 99999 1\r\n")); err != ErrInvalid {
+		t.Fatalf("expect ErrInvalid but got %v", err)
+	}
+	if _, _, err := Parse([]byte("PROXY TCP4 2001:db8::1 5.6.7.8 1 1\r\n")); err != ErrInvalid {
+		t.Fatalf("expect ErrInvalid but got %v", err)
+	}
+}
+
+func buildV2(cmd byte, fam byte, addrs []byte, tlvs []byte) []byte {
+	buf := append([]byte{}, v2Signature...)
+	buf = append(buf, 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(buf[14:], uint16(len(addrs)+len(tlvs)))
+	buf = append(buf, addrs...)
+	return append(buf, tlvs...)
+}
+
+func TestParse_V2(t *testing.T) {
+	addrs := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x1F, 0x90, 0x01, 0xBB}
+	tlvs := []byte{TLVTypeAWS, 0, 3, 'v', 'p', 'c'}
+	data := append(buildV2(0x1, 0x11, addrs, tlvs), "rest"...)
+	h, n, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[n:]) != "rest" {
+		t.Fatalf("expect rest but got %q", data[n:])
+	}
+	if h.Version != 2 || h.Command != CommandProxy {
+		t.Fatalf("unexpected header %+v", h)
+	}
+	if h.SrcAddr.String() != "10.0.0.1:8080" || h.DstAddr.String() != "10.0.0.2:443" {
+		t.Fatalf("unexpected addrs %s %s", h.SrcAddr, h.DstAddr)
+	}
+	if v, ok := h.TLV(TLVTypeAWS); !ok || string(v) != "vpc" {
+		t.Fatalf("unexpected tlv %q", v)
+	}
+
+	// 数据不足
+	if _, _, err = Parse(data[:20]); err != ErrIncomplete {
+		t.Fatalf("expect ErrIncomplete but got %v", err)
+	}
+	if _, _, err = Parse(data[:5]); err != ErrIncomplete {
+		t.Fatalf("expect ErrIncomplete but got %v", err)
+	}
+}
+
+func TestParse_V2Local(t *testing.T) {
+	h, _, err := Parse(buildV2(0x0, 0x00, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Command != CommandLocal || h.SrcAddr != nil {
+		t.Fatalf("unexpected local header %+v", h)
+	}
+}
+
+func TestParse_V2IPv6(t *testing.T) {
+	src, dst := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+	addrs := append(append(append([]byte{}, src...), dst...), 0, 1, 0, 2)
+	h, _, err := Parse(buildV2(0x1, 0x21, addrs, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.SrcAddr.String() != "[2001:db8::1]:1" || h.DstAddr.String() != "[2001:db8::2]:2" {
+		t.Fatalf("unexpected addrs %s %s", h.SrcAddr, h.DstAddr)
+	}
+}
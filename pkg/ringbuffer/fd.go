@@ -0,0 +1,85 @@
+package ringbuffer
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	minReadSize = initSize // ReadFromFd至少保证的空闲空间
+	maxReadSize = 1 << 16  // 连续读满后空闲空间最多扩到的大小
+)
+
+// ReadFromFd 用一次readv把fd的数据直接读到空闲空间（"write"之后、"read"之前）
+// 空闲空间不足时先扩容，上一次把空闲空间读满则扩大到当前大小（最多maxReadSize）
+func (r *RingBuffer) ReadFromFd(fd int) (n int, err error) {
+	want := minReadSize
+	if r.readFull && r.size > want {
+		want = r.size
+		if want > maxReadSize {
+			want = maxReadSize
+		}
+	}
+	if r.isEmpty { // 空的时候从头开始读，数据尽量连续
+		r.r, r.w = 0, 0
+	}
+	if free := r.Free(); free < want {
+		r.malloc(want - free)
+	}
+
+	var iov [2]unix.Iovec
+	cnt, space := 0, 0
+	addSpan := func(b []byte) {
+		if len(b) > 0 {
+			iov[cnt].Base = &b[0]
+			iov[cnt].SetLen(len(b))
+			cnt++
+			space += len(b)
+		}
+	}
+	if r.w >= r.r {
+		addSpan(r.buf[r.w:])
+		addSpan(r.buf[:r.r])
+	} else {
+		addSpan(r.buf[r.w:r.r])
+	}
+	nr, _, errno := unix.Syscall(unix.SYS_READV, uintptr(fd), uintptr(unsafe.Pointer(&iov[0])), uintptr(cnt))
+	if errno != 0 {
+		return 0, errno
+	}
+	n = int(nr)
+	if n > 0 {
+		r.w = (r.w + n) & r.mask
+		r.isEmpty = false
+	}
+	r.readFull = n == space
+	return n, nil
+}
+
+// WriteToFd 用一次writev把最多max字节（max<=0时全部）写到fd，并移动"read"指针
+func (r *RingBuffer) WriteToFd(fd int, max int) (n int, err error) {
+	if max <= 0 {
+		max = r.Length()
+	}
+	head, tail := r.LazyRead(max)
+	if len(head) == 0 {
+		return 0, nil
+	}
+	var iov [2]unix.Iovec
+	cnt := 1
+	iov[0].Base = &head[0]
+	iov[0].SetLen(len(head))
+	if len(tail) > 0 {
+		iov[1].Base = &tail[0]
+		iov[1].SetLen(len(tail))
+		cnt++
+	}
+	nw, _, errno := unix.Syscall(unix.SYS_WRITEV, uintptr(fd), uintptr(unsafe.Pointer(&iov[0])), uintptr(cnt))
+	if errno != 0 {
+		return 0, errno
+	}
+	n = int(nw)
+	r.Shift(n)
+	return n, nil
+}
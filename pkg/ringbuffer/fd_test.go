@@ -0,0 +1,81 @@
+package ringbuffer
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRingBuffer_ReadWriteFd(t *testing.T) {
+	var in, out [2]int
+	if err := unix.Pipe(in[:]); err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(in[0])
+	defer unix.Close(in[1])
+	if err := unix.Pipe(out[:]); err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(out[0])
+	defer unix.Close(out[1])
+
+	// empty, it will scale to initSize bytes.
+	rb := New(0)
+	_, _ = unix.Write(in[1], []byte("abcd"))
+	n, err := rb.ReadFromFd(in[0])
+	if err != nil || n != 4 {
+		t.Fatalf("expect read 4 bytes but got %d, err: %v", n, err)
+	}
+	if rb.Cap() != initSize {
+		t.Fatalf("expect cap %d but got %d", initSize, rb.Cap())
+	}
+	if !bytes.Equal(rb.ByteBuffer().Bytes(), []byte("abcd")) {
+		t.Fatalf("expect abcd but got %s", rb.ByteBuffer().Bytes())
+	}
+
+	// full, it will scale and keep the data.
+	_, _ = rb.Write(bytes.Repeat([]byte("y"), initSize-4))
+	_, _ = unix.Write(in[1], []byte("efgh"))
+	if n, err = rb.ReadFromFd(in[0]); err != nil || n != 4 {
+		t.Fatalf("expect read 4 bytes but got %d, err: %v", n, err)
+	}
+	if rb.Length() != initSize+4 {
+		t.Fatalf("expect len %d bytes but got %d. r.w=%d, r.r=%d", initSize+4, rb.Length(), rb.w, rb.r)
+	}
+	if b := rb.ByteBuffer().Bytes(); !bytes.HasPrefix(b, []byte("abcdyy")) || !bytes.HasSuffix(b, []byte("yyefgh")) {
+		t.Fatalf("expect abcdyy...yyefgh but got %s", b)
+	}
+
+	// free spans wrap around, readv fills the end and the beginning.
+	rb = New(8192)
+	_, _ = rb.Write(bytes.Repeat([]byte("x"), 8000))
+	rb.Shift(7990)
+	data := make([]byte, 300)
+	for i := range data {
+		data[i] = byte('a' + i%26)
+	}
+	_, _ = unix.Write(in[1], data)
+	if n, err = rb.ReadFromFd(in[0]); err != nil || n != len(data) {
+		t.Fatalf("expect read %d bytes but got %d, err: %v", len(data), n, err)
+	}
+	head, tail := rb.LazyReadAll()
+	if len(head) != 202 || len(tail) != 108 {
+		t.Fatalf("expect head 202 and tail 108 bytes but got %d and %d. r.w=%d, r.r=%d", len(head), len(tail), rb.w, rb.r)
+	}
+
+	want := append(bytes.Repeat([]byte("x"), 10), data...)
+	if n, err = rb.WriteToFd(out[1], 0); err != nil || n != len(want) {
+		t.Fatalf("expect write %d bytes but got %d, err: %v", len(want), n, err)
+	}
+	if !rb.IsEmpty() {
+		t.Fatalf("expect IsEmpty is true but got false")
+	}
+	got := make([]byte, len(want))
+	if _, err = unix.Read(out[0], got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("expect %q but got %q", want, got)
+	}
+}
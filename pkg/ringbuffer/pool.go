@@ -37,6 +37,9 @@ type Pool struct {
 	defaultSize uint64
 	maxSize     uint64
 
+	hits   uint64
+	misses uint64
+
 	pool sync.Pool
 }
 
@@ -56,11 +59,22 @@ func Get() *RingBuff { return defaultPool.Get() }
 func (p *Pool) Get() *RingBuffer {
 	v := p.pool.Get()
 	if v != nil {
+		atomic.AddUint64(&p.hits, 1)
 		return v.(*RingBuff)
 	}
+	atomic.AddUint64(&p.misses, 1)
 	return New(int(atomic.LoadUint64(&p.defaultSize)))
 }
 
+// Stats returns the hit and miss counts of the default pool.
+func Stats() (hits, misses uint64) { return defaultPool.Stats() }
+
+// Stats returns how many Get calls were served from the pool (hits) and
+// how many had to allocate a new ring-buffer (misses).
+func (p *Pool) Stats() (hits, misses uint64) {
+	return atomic.LoadUint64(&p.hits), atomic.LoadUint64(&p.misses)
+}
+
 // Put returns byte buffer to the pool.
 //
 // ByteBuffer.B mustn't be touched after returning it to the pool.
@@ -19,6 +19,7 @@ const initSize = 1 << 12 // 4096 bytes for the first-time allocation on ring-buf
 var ErrIsEmpty = errors.New("ring-buffer is empty")
 
 // RingBuffer is a circular buffer that implement io.ReaderWriter interface.
+// A nil *RingBuffer is an empty ring-buffer for the read-only methods, Shift and Reset, so the owner can acquire it lazily.
 type RingBuffer struct {
 	buf     []byte
 	size    int
@@ -26,6 +27,8 @@ type RingBuffer struct {
 	r       int // next position to read
 	w       int // next position to write
 	isEmpty bool
+
+	readFull bool // 上一次ReadFromFd读满了全部空闲空间
 }
 
 // New returns a new RingBuffer whose buffer has the given size.
@@ -44,7 +47,7 @@ func New(size int) *RingBuffer {
 
 // LazyRead reads the bytes with given length but will not move the pointer of "read".
 func (r *RingBuffer) LazyRead(len int) (head []byte, tail []byte) {
-	if r.isEmpty {
+	if r.IsEmpty() {
 		return
 	}
 
@@ -80,7 +83,7 @@ func (r *RingBuffer) LazyRead(len int) (head []byte, tail []byte) {
 
 // LazyReadAll reads the all bytes in this ring-buffer but will not move the pointer of "read".
 func (r *RingBuffer) LazyReadAll() (head []byte, tail []byte) {
-	if r.isEmpty {
+	if r.IsEmpty() {
 		return
 	}
 
@@ -99,7 +102,7 @@ func (r *RingBuffer) LazyReadAll() (head []byte, tail []byte) {
 
 // Shift shifts the "read" pointer.
 func (r *RingBuffer) Shift(n int) {
-	if n <= 0 {
+	if n <= 0 || r == nil {
 		return
 	}
 
@@ -244,6 +247,9 @@ func (r *RingBuffer) WriteByte(c byte) error {
 
 // Length returns the length of available read bytes.
 func (r *RingBuffer) Length() int {
+	if r == nil {
+		return 0
+	}
 	if r.r == r.w {
 		if r.isEmpty {
 			return 0
@@ -260,11 +266,17 @@ func (r *RingBuffer) Length() int {
 
 // Len returns the length of the underlying buffer.
 func (r *RingBuffer) Len() int {
+	if r == nil {
+		return 0
+	}
 	return len(r.buf)
 }
 
 // Cap returns the size of the underlying buffer.
 func (r *RingBuffer) Cap() int {
+	if r == nil {
+		return 0
+	}
 	return r.size
 }
 
@@ -294,7 +306,7 @@ func (r *RingBuffer) WriteString(s string) (n int, err error) {
 
 // ByteBuffer returns all available read bytes. It does not move the read pointer and only copy the available data.
 func (r *RingBuffer) ByteBuffer() *bytebuffer.ByteBuffer {
-	if r.isEmpty {
+	if r.IsEmpty() {
 		return nil
 	} else if r.w == r.r {
 		bb := bytebuffer.Get()
@@ -355,11 +367,14 @@ func (r *RingBuffer) IsFull() bool {
 
 // IsEmpty returns this ringbuffer is empty.
 func (r *RingBuffer) IsEmpty() bool {
-	return r.isEmpty
+	return r == nil || r.isEmpty
 }
 
 // Reset the read pointer and writer pointer to zero.
 func (r *RingBuffer) Reset() {
+	if r == nil {
+		return
+	}
 	r.r = 0
 	r.w = 0
 	r.isEmpty = true
@@ -377,6 +392,7 @@ func (r *RingBuffer) malloc(cap int) {
 	_, _ = r.Read(newBuf)
 	r.r = 0
 	r.w = oldLen
+	r.isEmpty = oldLen == 0
 	r.size = newCap
 	r.mask = newCap - 1
 	r.buf = newBuf
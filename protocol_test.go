@@ -0,0 +1,169 @@
+package limnet
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type closeInfo struct {
+	reason CloseReason
+	err    error
+}
+
+type protocolHandler struct {
+	DefaultEventHandler
+	packets chan string
+	closed  chan closeInfo
+}
+
+func newProtocolHandler() *protocolHandler {
+	return &protocolHandler{packets: make(chan string, 10), closed: make(chan closeInfo, 10)}
+}
+
+func (h *protocolHandler) OnPacket(c Conn, data []byte) []byte {
+	h.packets <- string(data)
+	return nil
+}
+
+func (h *protocolHandler) OnProtocolViolation(c Conn, err error) []byte {
+	return []byte("bye\n")
+}
+
+func (h *protocolHandler) OnClose(c Conn) {
+	h.closed <- closeInfo{reason: c.CloseReason(), err: c.CloseErr()}
+}
+
+var errBadLine = fmt.Errorf("%w：bad line", ErrProtocolViolation)
+
+// strictLineUnPacket 按换行拆包，收到bad行时违反协议
+func strictLineUnPacket(c Conn) ([]byte, error) {
+	data, err := lineUnPacket(c)
+	if err == nil && string(data) == "bad" {
+		return nil, errBadLine
+	}
+	return data, err
+}
+
+func expectViolation(t *testing.T, h *protocolHandler, want error) {
+	t.Helper()
+	info := recv(t, h.closed)
+	if info.reason != CloseProtocolViolation || !errors.Is(info.err, want) {
+		t.Fatalf("expect %s(%v) but got %s(%v)", CloseProtocolViolation, want, info.reason, info.err)
+	}
+}
+
+func TestUnPacket_Incomplete(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := newProtocolHandler()
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(strictLineUnPacket))
+		conn := dial(t, addr)
+		if _, err := conn.Write([]byte("hel")); err != nil {
+			t.Fatal(err)
+		}
+		select { // 不完整的包等待更多数据，不关闭连接
+		case p := <-h.packets:
+			t.Fatalf("expect no packet but got %q", p)
+		case info := <-h.closed:
+			t.Fatalf("expect conn kept open but closed: %s", info.reason)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if _, err := conn.Write([]byte("lo\n")); err != nil {
+			t.Fatal(err)
+		}
+		if p := recv(t, h.packets); p != "hello" {
+			t.Fatalf("expect hello but got %q", p)
+		}
+	})
+}
+
+func TestUnPacket_ProtocolViolation(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := newProtocolHandler()
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(strictLineUnPacket))
+		conn := dial(t, addr)
+		if _, err := conn.Write([]byte("ok\nbad\n")); err != nil {
+			t.Fatal(err)
+		}
+		if p := recv(t, h.packets); p != "ok" {
+			t.Fatalf("expect ok but got %q", p)
+		}
+		expectViolation(t, h, errBadLine)
+		// 关闭前写出了告别数据
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		r := bufio.NewReader(conn)
+		if line := readLine(t, r); line != "bye" {
+			t.Fatalf("expect bye but got %q", line)
+		}
+		if _, err := r.ReadByte(); err != io.EOF {
+			t.Fatalf("expect EOF but got %v", err)
+		}
+	})
+}
+
+func TestMaxInboundBuffer(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		h := newProtocolHandler()
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(strictLineUnPacket), WithMaxInboundBuffer(16))
+		conn := dial(t, addr)
+		// 不超过限制的包正常处理
+		if _, err := conn.Write([]byte("0123456789\n")); err != nil {
+			t.Fatal(err)
+		}
+		if p := recv(t, h.packets); p != "0123456789" {
+			t.Fatalf("expect 0123456789 but got %q", p)
+		}
+		if _, err := conn.Write(bytes.Repeat([]byte("x"), 32)); err != nil {
+			t.Fatal(err)
+		}
+		expectViolation(t, h, ErrInboundBufferExceeded)
+	})
+}
+
+func TestMaxInboundBuffer_WS(t *testing.T) {
+	h := newProtocolHandler()
+	startServer(t, h, WithWSAddr("127.0.0.1:17152"), WithUnPacket(strictLineUnPacket), WithMaxInboundBuffer(16))
+	conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:17152", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 不完整的包跨消息拼接
+	for _, msg := range []string{"hel", "lo\n"} {
+		if err = conn.WriteMessage(websocket.BinaryMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p := recv(t, h.packets); p != "hello" {
+		t.Fatalf("expect hello but got %q", p)
+	}
+	// 单个消息超过限制
+	if err = conn.WriteMessage(websocket.BinaryMessage, bytes.Repeat([]byte("x"), 32)); err != nil {
+		t.Fatal(err)
+	}
+	expectViolation(t, h, ErrInboundBufferExceeded)
+}
+
+func TestProtocolViolation_WS(t *testing.T) {
+	h := newProtocolHandler()
+	startServer(t, h, WithWSAddr("127.0.0.1:17153"), WithUnPacket(strictLineUnPacket))
+	conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:17153", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = conn.WriteMessage(websocket.BinaryMessage, []byte("bad\n")); err != nil {
+		t.Fatal(err)
+	}
+	expectViolation(t, h, errBadLine)
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "bye\n" {
+		t.Fatalf("expect bye but got %q, %v", msg, err)
+	}
+}
@@ -0,0 +1,108 @@
+package limnet
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/tangtaoit/limnet/pkg/proxyproto"
+	"go.uber.org/zap"
+)
+
+// ProxyProtocolMode PROXY协议模式
+type ProxyProtocolMode int
+
+const (
+	// ProxyProtocolOff 不解析PROXY协议
+	ProxyProtocolOff ProxyProtocolMode = iota
+	// ProxyProtocolOptional 来自信任代理的连接如果带有PROXY协议头则解析，没有则按直连处理
+	ProxyProtocolOptional
+	// ProxyProtocolStrict 只接受信任代理的连接，且必须带有PROXY协议头
+	ProxyProtocolStrict
+)
+
+// RejectProxyUntrusted strict模式下连接不是来自信任的代理
+const RejectProxyUntrusted RejectReason = "proxy_untrusted"
+
+// ErrProxyTrustedRequired 开启PROXY协议时没有指定信任的代理
+var ErrProxyTrustedRequired = errors.New("开启PROXY协议必须指定信任的代理CIDR列表")
+
+// proxyTrusted 连接来源是否为信任的代理，没有指定信任的代理时不信任任何来源
+func (l *LIMNet) proxyTrusted(addr string) bool {
+	return l.opts.ProxyTrusted != nil && l.opts.ProxyTrusted.AllowedAddr(addr)
+}
+
+// handleProxyHeader 解析连接开始的PROXY协议头，返回true表示已处理完成可以开始解包
+func (c *TCPConn) handleProxyHeader() bool {
+	header, n, err := proxyproto.Parse(c.Read())
+	switch err {
+	case nil:
+		c.ShiftN(n)
+		if header.Command == proxyproto.CommandProxy && header.SrcAddr != nil {
+			c.addr = header.SrcAddr.String()
+		}
+		c.proxyHeader = header
+		if !c.admit() {
+			return false
+		}
+		c.proxyPending = false
+		c.listener.onConnect(c)
+		return c.connected.Get()
+	case proxyproto.ErrIncomplete:
+		return false
+	case proxyproto.ErrNotProxy:
+		if c.lnet.opts.ProxyProtocol == ProxyProtocolOptional {
+			if !c.admit() {
+				return false
+			}
+			c.proxyPending = false
+			c.listener.onConnect(c)
+			return c.connected.Get()
+		}
+	}
+	c.Warn("PROXY协议头错误，关闭连接！", zap.Error(err), zap.String("addr", c.addr))
+	_ = c.closeWith(CloseProtocolViolation, err)
+	return false
+}
+
+// admit 按解析出的真实客户端地址做ip访问控制和单个IP的连接数限制，被拒绝时关闭连接（不会触发连接事件）
+func (c *TCPConn) admit() bool {
+	if !c.listener.acl().AllowedAddr(c.addr) {
+		c.listener.reject(c.addr, RejectACL)
+		_ = c.closeWith(CloseLocal, nil)
+		return false
+	}
+	if reason, ok := c.lnet.limiter.acquireIP(c.addr); !ok {
+		c.listener.reject(c.addr, reason)
+		_ = c.closeWith(CloseLocal, nil)
+		return false
+	}
+	c.limitAddr = c.addr
+	return true
+}
+
+// forwardedAddr 获取信任代理转发的websocket请求的真实客户端地址（X-Forwarded-For 或 X-Real-IP），
+// 请求不是来自信任的代理或者没有转发头则返回空
+func (l *LIMNet) forwardedAddr(r *http.Request) string {
+	if !l.proxyTrusted(r.RemoteAddr) { // 客户端直连时转发头可以随意伪造
+		return ""
+	}
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		ips := strings.Split(xff, ",")
+		// 从右往左跳过信任的代理，第一个不信任的地址即为客户端地址
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(ips[i]))
+			if ip == nil {
+				break
+			}
+			if i == 0 || !l.opts.ProxyTrusted.Allowed(ip) {
+				return ip.String()
+			}
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
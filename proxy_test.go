@@ -0,0 +1,130 @@
+package limnet
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestForwardedAddr(t *testing.T) {
+	opts := NewOption()
+	if err := WithProxyProtocol(ProxyProtocolOptional, nil)(opts); err != ErrProxyTrustedRequired {
+		t.Fatalf("expect ErrProxyTrustedRequired but got %v", err)
+	}
+	if err := WithProxyProtocol(ProxyProtocolOptional, []string{"10.0.0.0/8"})(opts); err != nil {
+		t.Fatal(err)
+	}
+	l := &LIMNet{opts: opts}
+
+	tests := []struct {
+		remote string
+		xff    string
+		real   string
+		want   string
+	}{
+		{remote: "1.2.3.4:5000", xff: "9.9.9.9", want: ""},                   // 直连的客户端伪造转发头
+		{remote: "1.2.3.4:5000", real: "9.9.9.9", want: ""},                  // 直连的客户端伪造X-Real-IP
+		{remote: "10.0.0.1:5000", xff: "9.9.9.9, 10.0.0.2", want: "9.9.9.9"}, // 跳过信任的代理
+		{remote: "10.0.0.1:5000", xff: "8.8.8.8, 9.9.9.9", want: "9.9.9.9"},  // 不信任的地址之前的都可能是伪造的
+		{remote: "10.0.0.1:5000", real: "9.9.9.9", want: "9.9.9.9"},
+	}
+	for _, tt := range tests {
+		r := &http.Request{RemoteAddr: tt.remote, Header: http.Header{}}
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.real != "" {
+			r.Header.Set("X-Real-IP", tt.real)
+		}
+		if got := l.forwardedAddr(r); got != tt.want {
+			t.Errorf("remote %s xff %q real %q: expect %q but got %q", tt.remote, tt.xff, tt.real, tt.want, got)
+		}
+	}
+}
+
+type proxyAddrHandler struct {
+	DefaultEventHandler
+	connected chan string
+	rejected  chan string
+}
+
+func (h *proxyAddrHandler) OnConnect(c Conn) {
+	h.connected <- c.RemoteAddr().String()
+}
+
+func (h *proxyAddrHandler) OnReject(addr string, reason RejectReason) {
+	h.rejected <- addr + " " + string(reason)
+}
+
+func TestProxyResolvedAddrLimits(t *testing.T) {
+	h := &proxyAddrHandler{connected: make(chan string, 10), rejected: make(chan string, 10)}
+	l, err := NewServer(h, WithAddr("tcp://127.0.0.1:17101"), WithWSAddr("127.0.0.1:17102"),
+		WithProxyProtocol(ProxyProtocolOptional, []string{"127.0.0.0/8"}),
+		WithMaxConnsPerIP(1), WithACL(nil, []string{"9.9.9.9"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Stop()
+
+	expect := func(ch chan string, want string) {
+		t.Helper()
+		select {
+		case got := <-ch:
+			if got != want {
+				t.Fatalf("expect %q but got %q", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expect %q but timeout", want)
+		}
+	}
+	dialProxy := func(src string) net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", "127.0.0.1:17101")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(conn, "PROXY TCP4 %s 127.0.0.1 5000 17101\r\n", src)
+		return conn
+	}
+
+	// 访问控制和单个IP的连接数都按PROXY协议头里的地址，而不是代理的地址
+	c1 := dialProxy("8.8.8.8")
+	defer c1.Close()
+	expect(h.connected, "8.8.8.8:5000")
+	c2 := dialProxy("7.7.7.7")
+	defer c2.Close()
+	expect(h.connected, "7.7.7.7:5000")
+	c3 := dialProxy("8.8.8.8")
+	defer c3.Close()
+	expect(h.rejected, "8.8.8.8:5000 "+string(RejectMaxConnsPerIP))
+	c4 := dialProxy("9.9.9.9")
+	defer c4.Close()
+	expect(h.rejected, "9.9.9.9:5000 "+string(RejectACL))
+
+	// websocket按转发头里的地址
+	dialWS := func(src string) (*websocket.Conn, error) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:17102", http.Header{"X-Forwarded-For": {src}})
+		return conn, err
+	}
+	ws1, err := dialWS("6.6.6.6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws1.Close()
+	expect(h.connected, "6.6.6.6:0")
+	if _, err = dialWS("6.6.6.6"); err == nil {
+		t.Fatal("expect rejected")
+	}
+	expect(h.rejected, "6.6.6.6 "+string(RejectMaxConnsPerIP))
+	if _, err = dialWS("9.9.9.9"); err == nil {
+		t.Fatal("expect rejected")
+	}
+	expect(h.rejected, "9.9.9.9 "+string(RejectACL))
+}
@@ -0,0 +1,177 @@
+package limnet
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxSendfileChunk 一次sendfile最多发送的字节数，避免一个大文件长时间占用eventloop
+const maxSendfileChunk = 4 << 20
+
+// maxRingFileChunk io_uring模式下每次读到内存里发送的文件块大小
+const maxRingFileChunk = 256 << 10
+
+// fileSegment 排队等待sendfile的文件段
+type fileSegment struct {
+	fd     int   // dup出来的文件fd，发送完或者连接关闭时关闭
+	offset int64 // 下一次发送的文件偏移
+	remain int64 // 还没发送的字节数
+	gap    int   // 此文件段之前还需要写出的outboundBuffer字节数
+}
+
+func (f *fileSegment) close() {
+	if f.fd >= 0 {
+		_ = unix.Close(f.fd)
+		f.fd = -1
+	}
+}
+
+// SendFile 用sendfile零拷贝发送文件段，f在调用返回后即可关闭（内部会dup一个fd）
+func (c *TCPConn) SendFile(f *os.File, offset, count int64) error {
+	if !c.connected.Get() {
+		return ErrConnectionClosed
+	}
+	count, err := fileSegmentSize(f, offset, count)
+	if err != nil || count == 0 {
+		return err
+	}
+	fd, err := dupFile(f)
+	if err != nil {
+		return err
+	}
+	return c.stage(nil, &fileSegment{fd: fd, offset: offset, remain: count}, nil, nil)
+}
+
+// queueFile 文件段排在已写入的数据之后，没有待写出的数据时直接发送
+func (c *TCPConn) queueFile(seg *fileSegment) {
+	if !c.connected.Get() {
+		seg.close()
+		return
+	}
+	if c.writeShut() {
+		seg.close()
+		c.listener.onError(c, ErrWriteClosed)
+		return
+	}
+	pending := c.writePending()
+	seg.gap = c.outboundBuffer.Length() - c.fileGap
+	c.fileGap += seg.gap
+	c.files = append(c.files, seg)
+	c.queued += seg.remain
+	if c.loop.IsRing() { // io_uring没有可写事件，由send完成事件驱动按块发送
+		if err := c.submitSend(); err != nil {
+			c.listener.onError(c, err)
+		}
+		return
+	}
+	if pending { // 可写事件已注册，等handleWrite按顺序发送
+		return
+	}
+	_ = c.handleWrite()
+	if c.connected.Get() && c.writePending() {
+		if err := c.enableWrite(); err != nil {
+			c.listener.onError(c, err)
+		}
+	}
+}
+
+// sendFile 发送文件段，done表示文件段已经全部发送
+func (c *TCPConn) sendFile(seg *fileSegment) (done bool, err error) {
+	n := seg.remain
+	if n > maxSendfileChunk {
+		n = maxSendfileChunk
+	}
+	offset := seg.offset // 不同系统对offset的更新不一致，自己维护偏移
+	written, err := unix.Sendfile(c.fd, seg.fd, &offset, int(n))
+	if written > 0 {
+		seg.offset += int64(written)
+		seg.remain -= int64(written)
+		c.written += int64(written)
+		c.lnet.metrics.BytesOut(TransportTCP, written)
+	}
+	if err != nil {
+		if err == unix.EAGAIN && written > 0 { // 部分发送（bsd）
+			return false, nil
+		}
+		return false, err
+	}
+	if written == 0 { // 文件在发送期间被截断
+		return false, io.ErrUnexpectedEOF
+	}
+	return seg.remain == 0, nil
+}
+
+// submitFileChunk io_uring模式下把文件段的下一块读到内存里发送，同时只有一块在发送
+func (c *TCPConn) submitFileChunk() error {
+	seg := c.files[0]
+	n := seg.remain
+	if n > maxRingFileChunk {
+		n = maxRingFileChunk
+	}
+	if int64(cap(c.fileChunk)) < n {
+		c.fileChunk = make([]byte, n)
+	}
+	m, err := unix.Pread(seg.fd, c.fileChunk[:n], seg.offset)
+	if err == nil && m == 0 { // 文件在发送期间被截断
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.listener.onError(c, err)
+		return c.closeWith(CloseWriteError, err)
+	}
+	c.sending = append(c.sending[:0], c.fileChunk[:m])
+	c.sendingFile = true
+	c.sendPending = 1
+	return c.loop.SubmitSend(uint64(c.id), c.fd, c.sending)
+}
+
+// fileChunkSent io_uring模式下文件块发送了n个字节，文件段发送完后关闭
+func (c *TCPConn) fileChunkSent(n int) {
+	seg := c.files[0]
+	seg.offset += int64(n)
+	seg.remain -= int64(n)
+	c.written += int64(n)
+	c.lnet.metrics.BytesOut(TransportTCP, n)
+	if seg.remain > 0 {
+		return
+	}
+	seg.close()
+	c.files[0] = nil
+	c.files = c.files[1:]
+	if len(c.files) == 0 {
+		c.fileChunk = nil
+	}
+}
+
+// fileSegmentSize count<=0时返回offset到文件末尾的长度
+func fileSegmentSize(f *os.File, offset, count int64) (int64, error) {
+	if count > 0 {
+		return count, nil
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if count = fi.Size() - offset; count < 0 {
+		count = 0
+	}
+	return count, nil
+}
+
+// dupFile 复制文件fd，避免调用方关闭文件影响排队中的发送
+func dupFile(f *os.File) (int, error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	var dupErr error
+	if err = rc.Control(func(sysfd uintptr) {
+		fd, dupErr = unix.FcntlInt(sysfd, unix.F_DUPFD_CLOEXEC, 0)
+	}); err != nil {
+		return -1, err
+	}
+	return fd, dupErr
+}
@@ -0,0 +1,120 @@
+package limnet
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// tempFile 创建内容为size个字节的临时文件
+func tempFile(t *testing.T, size int) (*os.File, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 253)
+	}
+	name := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+	return f, data
+}
+
+type sendFileHandler struct {
+	DefaultEventHandler
+	f    *os.File
+	errs chan error
+}
+
+func (h *sendFileHandler) OnPacket(c Conn, data []byte) []byte {
+	send := func() error {
+		if err := c.Write([]byte("head\n")); err != nil {
+			return err
+		}
+		if err := c.SendFile(h.f, 0, 0); err != nil {
+			return err
+		}
+		if err := c.SendFile(h.f, 10, 100); err != nil {
+			return err
+		}
+		return c.Write([]byte("tail\n"))
+	}
+	switch string(data) {
+	case "loop": // 在eventloop里写
+		h.errs <- send()
+	case "async": // 在其他goroutine里写
+		go func() { h.errs <- send() }()
+	}
+	return nil
+}
+
+func TestSendFile_Order(t *testing.T) {
+	eachBackend(t, func(t *testing.T, backend PollerBackend) {
+		f, data := tempFile(t, 3<<20) // 大于io_uring模式下的文件块
+		h := &sendFileHandler{f: f, errs: make(chan error, 2)}
+		_, addr := startServer(t, h, WithPoller(backend), WithUnPacket(lineUnPacket))
+		conn := dial(t, addr)
+		r := bufio.NewReader(conn)
+
+		for _, mode := range []string{"loop", "async"} {
+			if _, err := conn.Write([]byte(mode + "\n")); err != nil {
+				t.Fatal(err)
+			}
+			if err := recv(t, h.errs); err != nil {
+				t.Fatal(err)
+			}
+			if line := readLine(t, r); line != "head" {
+				t.Fatalf("%s: expect head but got %q", mode, line)
+			}
+			got := make([]byte, len(data)+100)
+			if _, err := io.ReadFull(r, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got[:len(data)], data) || !bytes.Equal(got[len(data):], data[10:110]) {
+				t.Fatalf("%s: file content mismatch", mode)
+			}
+			if line := readLine(t, r); line != "tail" {
+				t.Fatalf("%s: expect tail but got %q", mode, line)
+			}
+		}
+	})
+}
+
+func TestSendFile_WS(t *testing.T) {
+	f, data := tempFile(t, 3<<20)
+	h := &sendFileHandler{f: f, errs: make(chan error, 2)}
+	startServer(t, h, WithWSAddr("127.0.0.1:17141"))
+	conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:17141", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = conn.WriteMessage(websocket.BinaryMessage, []byte("loop")); err != nil {
+		t.Fatal(err)
+	}
+	if err = recv(t, h.errs); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]byte{[]byte("head\n"), data, data[10:110], []byte("tail\n")}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for i, w := range want {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg, w) {
+			t.Fatalf("message %d mismatch (%d bytes)", i, len(msg))
+		}
+	}
+}
@@ -1,12 +1,17 @@
 package limnet
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"runtime"
 	"strconv"
 	"strings"
+	gosync "sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/RussellLuo/timingwheel"
 	"github.com/tangtaoit/limnet/pkg/eventloop"
@@ -24,119 +29,339 @@ type LIMNet struct {
 	opts         *Options
 	limlog.Log
 	nextLoopIndex int
-	tcp           *TCPServer
-	ws            *WSServer
+	tcp           *TCPServer // 默认的tcp服务
+	ws            *WSServer  // 默认的websocket服务
+	listeners     []*Listener
 	eventHandler  EventHandler
 	timingWheel   *timingwheel.TimingWheel
 	idGen         int64
+	metrics       Metrics
+	metricsSrv    *http.Server // 独立的指标http服务
+	admin         *AdminServer
+	limiter       *connLimiter
+	acl           atomic.Value                                   // 当前的ip访问控制 aclHolder
+	conns         gosync.Map                                     // 所有连接 [id]Conn
+	evictStop     chan struct{}                                  // 停止驱逐慢消费者，未开启驱逐为nil
+	backlogged    map[*eventloop.EventLoop]map[*TCPConn]struct{} // 每个eventloop里输出有积压的tcp连接，只在所属eventloop里读写
+	loopsWait     sync.WaitGroupWrapper
+	state         int32        // 服务状态 stateInit、stateRunning、stateStopped
+	lifecycle     gosync.Mutex // 串行化Start、Stop和AddListener，Stop会等正在进行的Start完成后再停止
 }
 
-// New 创建server
+const (
+	stateInit    int32 = iota // 创建后还没有启动
+	stateRunning              // 已经启动
+	stateStopped              // 已经停止或者启动失败
+)
+
+// ErrServerStarted 服务已经启动过（停止后不能再次启动）
+var ErrServerStarted = errors.New("服务已经启动过")
+
+// New 创建server 创建失败会panic，需要处理错误请使用 NewServer
 func New(eventHandler EventHandler, optFuncs ...Option) *LIMNet {
+	l, err := NewServer(eventHandler, optFuncs...)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// NewServer 创建server
+func NewServer(eventHandler EventHandler, optFuncs ...Option) (*LIMNet, error) {
 	opts := NewOption()
 	for _, opt := range optFuncs {
 		if opt != nil {
 			if err := opt(opts); err != nil {
-				panic(err)
+				return nil, err
 			}
 		}
 	}
+	if eventHandler == nil {
+		if opts.ConnHandlerFactory == nil {
+			return nil, errors.New("事件处理者不能为空")
+		}
+		eventHandler = &DefaultEventHandler{} // 连接的事件都交给工厂创建的处理者
+	}
+	if opts.ProxyProtocol != ProxyProtocolOff && opts.ProxyTrusted == nil {
+		return nil, ErrProxyTrustedRequired
+	}
+	if opts.AdminOn && opts.AdminAddr == "" && opts.WSAddr != "" && opts.AdminToken == "" {
+		return nil, ErrAdminTokenRequired
+	}
+	if opts.TimingWheelTick < time.Millisecond {
+		return nil, errors.New("时间轮轮训间隔必须大于等于1ms")
+	}
 	l := &LIMNet{
 		opts:         opts,
 		eventHandler: eventHandler,
 		timingWheel:  timingwheel.NewTimingWheel(opts.TimingWheelTick, opts.TimingWheelSize),
 		Log:          limlog.NewLIMLog("LIMNet"),
+		metrics:      opts.Metrics,
+		limiter:      newConnLimiter(opts),
+	}
+	if l.metrics == nil {
+		l.metrics = NewDefaultMetrics()
 	}
+	l.SetACL(opts.ACL)
+	if opts.BufferDebug {
+		bufferDebug.Set(true)
+	}
+	if err := l.init(); err != nil {
+		l.release()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *LIMNet) init() error {
 	var err error
-	l.listenerLoop, err = eventloop.New()
+	l.listenerLoop, err = l.newEventLoop()
 	if err != nil {
-		panic(err)
+		return err
 	}
+	l.listenerLoop.SetErrorHandler(l.handleLoopError)
 	// 初始化连接的eventLoop
-	l.initConnectEventLoop()
-
-	l.tcp = NewTCPServer(l)
-	l.ws = NewWSServer(l)
+	if err = l.initConnectEventLoop(); err != nil {
+		return err
+	}
 
-	return l
+	// 默认的tcp监听器
+	tcpListener, err := l.AddListener(l.opts.Addr, l.eventHandler, nil)
+	if err != nil {
+		return err
+	}
+	l.tcp = tcpListener.tcp
+	// 配置了websocket地址才开启默认的websocket监听器
+	if l.opts.WSAddr != "" {
+		wsListener, err := l.AddListener("ws://"+l.opts.WSAddr, l.eventHandler, nil)
+		if err != nil {
+			return err
+		}
+		l.ws = wsListener.ws
+	}
+	l.admin = NewAdminServer(l)
+	return nil
 }
 
-func (l *LIMNet) initConnectEventLoop() {
+func (l *LIMNet) initConnectEventLoop() error {
 	if l.opts.ConnEventLoopNum <= 0 {
 		l.opts.ConnEventLoopNum = runtime.NumCPU()
 	}
-	wloops := make([]*eventloop.EventLoop, l.opts.ConnEventLoopNum)
+	l.connectLoops = make([]*eventloop.EventLoop, 0, l.opts.ConnEventLoopNum)
+	l.backlogged = make(map[*eventloop.EventLoop]map[*TCPConn]struct{}, l.opts.ConnEventLoopNum)
 	for i := 0; i < l.opts.ConnEventLoopNum; i++ {
-		l, err := eventloop.New()
+		loop, err := l.newEventLoop()
 		if err != nil {
-			panic(err)
+			return err
 		}
-		wloops[i] = l
+		loop.SetErrorHandler(l.handleLoopError)
+		l.connectLoops = append(l.connectLoops, loop)
+		l.backlogged[loop] = map[*TCPConn]struct{}{}
 	}
-	l.connectLoops = wloops
+	return nil
 }
 
-// ---------- 实现 EventHandler ----------
+// newEventLoop 根据配置的IO模型创建eventloop
+func (l *LIMNet) newEventLoop() (*eventloop.EventLoop, error) {
+	if l.opts.Poller == PollerIOURing {
+		if limpoller.IOURingAvailable() {
+			return eventloop.NewRing()
+		}
+		l.Warn("io_uring不可用，回退到epoll")
+		l.opts.Poller = PollerEpoll
+	}
+	return eventloop.New()
+}
 
-// Handle 处理事件通知
-func (l *LIMNet) Handle(fd int, event limpoller.Event) {
-	if event&limpoller.EventRead != 0 {
-		connfd, sa, err := unix.Accept(fd) // 接受连接的fd
-		if err != nil {
-			if err != unix.EAGAIN {
-				l.Error("accept:", zap.Error(err), zap.Int("fd", fd))
-				panic(err)
+// release 创建失败时释放已经创建的监听fd和eventloop
+func (l *LIMNet) release() {
+	for _, ln := range l.listeners {
+		if ln.tcp != nil {
+			_ = unix.Close(ln.tcp.acceptFd)
+			ln.tcp.closeSpareFd()
+		}
+	}
+	if l.listenerLoop != nil {
+		_ = l.listenerLoop.Stop()
+	}
+	for _, loop := range l.connectLoops {
+		_ = loop.Stop()
+	}
+}
+
+// handleLoopError eventloop中与连接无关的错误（job错误、panic）交给默认的事件处理者
+func (l *LIMNet) handleLoopError(err error) {
+	l.Warn("eventloop执行出错！", zap.Error(err))
+	if h, ok := l.eventHandler.(ErrorHandler); ok {
+		h.OnError(nil, err)
+	}
+}
+
+// Start 开始监听和运行eventloop（不阻塞），任意一个监听失败都会返回错误
+func (l *LIMNet) Start() error {
+	l.lifecycle.Lock()
+	defer l.lifecycle.Unlock()
+	if !atomic.CompareAndSwapInt32(&l.state, stateInit, stateRunning) {
+		return ErrServerStarted
+	}
+	var started []*WSServer
+	// 启动失败时关闭已经开启的服务并释放监听fd和eventloop，之后不能再启动
+	fail := func(err error) error {
+		for _, ws := range started {
+			_ = ws.Stop()
+		}
+		if l.metricsSrv != nil {
+			_ = l.metricsSrv.Close()
+		}
+		atomic.StoreInt32(&l.state, stateStopped)
+		l.release()
+		return err
+	}
+	for _, ln := range l.listeners {
+		if ln.ws != nil {
+			if err := ln.ws.Start(); err != nil {
+				return fail(err)
 			}
-			return
+			started = append(started, ln.ws)
 		}
-		if err := unix.SetNonblock(connfd, true); err != nil { // 连接设置为不阻塞
-			_ = unix.Close(connfd)
-			l.Error("set nonblock:", zap.Error(err))
-			return
+	}
+	if err := l.startMetricsServer(); err != nil {
+		return fail(err)
+	}
+	if l.opts.AdminOn {
+		if l.opts.AdminAddr != "" {
+			if err := l.admin.Start(); err != nil {
+				return fail(err)
+			}
+		} else if l.ws == nil {
+			l.Warn("管理接口没有设置地址且没有开启websocket服务，管理接口不可用")
 		}
-		// 处理新的连接
-		l.handleNewConnection(connfd, sa)
 	}
+	l.timingWheel.Start()
+	if l.opts.EvictMemory > 0 {
+		l.evictStop = make(chan struct{})
+		go l.runEviction(l.evictStop)
+	}
+	for i := 0; i < len(l.connectLoops); i++ {
+		l.loopsWait.AddAndRun(l.connectLoops[i].Run)
+	}
+	l.loopsWait.AddAndRun(l.listenerLoop.Run)
+	return nil
 }
 
-// Run 运行
+// Run 运行（阻塞直到服务停止） 启动失败会panic，需要处理错误请使用 Start
 func (l *LIMNet) Run() {
-	sw := sync.WaitGroupWrapper{}
-	l.timingWheel.Start()
-	l.ws.Start()
-	length := len(l.connectLoops)
-	for i := 0; i < length; i++ {
-		sw.AddAndRun(l.connectLoops[i].Run)
+	if err := l.Start(); err != nil {
+		l.Error("启动失败！", zap.Error(err))
+		panic(err)
 	}
-	sw.AddAndRun(l.listenerLoop.Run)
-	sw.Wait()
+	l.loopsWait.Wait()
 	l.Error("Quit！")
 }
 
-// Stop 停止服务
+// Stop 停止服务 没有启动过（或者启动失败）的服务只释放资源，重复调用直接返回
+// 某一步出错时继续完成后面的停止，返回第一个错误
 func (l *LIMNet) Stop() error {
+	l.lifecycle.Lock()
+	defer l.lifecycle.Unlock()
+	if !atomic.CompareAndSwapInt32(&l.state, stateRunning, stateStopped) {
+		if atomic.CompareAndSwapInt32(&l.state, stateInit, stateStopped) {
+			l.release()
+		}
+		return nil
+	}
+	var firstErr error
+	record := func(err error) bool {
+		if err == nil {
+			return true
+		}
+		l.Error("停止服务失败！", zap.Error(err))
+		if firstErr == nil {
+			firstErr = err
+		}
+		return false
+	}
 	l.timingWheel.Stop()
-	err := l.tcp.Stop()
-	if err != nil {
-		return err
+	if l.evictStop != nil {
+		close(l.evictStop)
+		l.evictStop = nil
 	}
-	err = l.ws.Stop()
-	if err != nil {
-		return err
+	var stopping []*TCPServer // 已经通知停止的tcp服务，需要等待监听fd关闭
+	for _, ln := range l.listeners {
+		if ln.tcp != nil {
+			if record(ln.tcp.Stop()) {
+				stopping = append(stopping, ln.tcp)
+			}
+		} else if ln.ws != nil {
+			record(ln.ws.Stop())
+		}
+	}
+	record(l.admin.Stop())
+	if l.metricsSrv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		record(l.metricsSrv.Shutdown(ctx))
+	}
+	for _, s := range stopping {
+		<-s.Stopped
+	}
+	record(l.listenerLoop.Stop())
+	l.conns.Range(func(key, value interface{}) bool { // eventloop停止时关闭的连接
+		if c, ok := value.(*TCPConn); ok {
+			c.cause.set(CloseServerStop, nil)
+		}
+		return true
+	})
+	for k := range l.connectLoops {
+		record(l.connectLoops[k].Stop())
+	}
+	return firstErr
+}
+
+// startMetricsServer 如果配置了MetricsAddr则开启独立的指标http服务
+func (l *LIMNet) startMetricsServer() error {
+	if l.opts.MetricsAddr == "" {
+		return nil
 	}
-	err = l.listenerLoop.Stop()
+	ln, err := net.Listen("tcp", l.opts.MetricsAddr)
 	if err != nil {
 		return err
 	}
-	for k := range l.connectLoops {
-		if err := l.connectLoops[k].Stop(); err != nil {
-			l.Error("stop conn fail ", zap.Error(err))
-		}
+	path := l.opts.MetricsPath
+	if path == "" {
+		path = "/metrics"
 	}
-	<-l.tcp.Stopped
+	mux := http.NewServeMux()
+	mux.Handle(path, l.MetricsHandler())
+	l.metricsSrv = &http.Server{Addr: l.opts.MetricsAddr, Handler: mux}
+	go func() {
+		err := l.metricsSrv.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			l.Error("指标服务异常退出！", zap.Error(err))
+		}
+	}()
 	return nil
 }
 
+// GetConn 通过连接ID获取连接，不存在返回nil
+func (l *LIMNet) GetConn(id int64) Conn {
+	v, ok := l.conns.Load(id)
+	if !ok {
+		return nil
+	}
+	return v.(Conn)
+}
+
+// Listeners 获取所有监听器
+func (l *LIMNet) Listeners() []*Listener {
+	return l.listeners
+}
+
+// Metrics 获取指标收集对象
+func (l *LIMNet) Metrics() Metrics {
+	return l.metrics
+}
+
 // Close 关闭
 func (l *LIMNet) Close() error {
 	return nil
@@ -149,23 +374,10 @@ func (l *LIMNet) GetTCPServer() *TCPServer {
 
 // ---------- 处理新的连接 ----------
 
-func (l *LIMNet) nextLoop() *eventloop.EventLoop {
-	loop := l.connectLoops[l.nextLoopIndex]
+func (l *LIMNet) nextLoop() (*eventloop.EventLoop, int) {
+	index := l.nextLoopIndex
 	l.nextLoopIndex = (l.nextLoopIndex + 1) % len(l.connectLoops)
-	return loop
-}
-
-func (l *LIMNet) handleNewConnection(connfd int, sa unix.Sockaddr) {
-	loop := l.nextLoop() // 获取conn的eventloop
-	clientID := atomic.AddInt64(&l.idGen, 1)
-	conn := NewTCPConn(clientID, connfd, sockAddrToString(sa), loop, l) // 创建一个新的连接
-
-	l.eventHandler.OnConnect(conn) // 触发连接事件
-
-	// 绑定连接fd对应的处理者
-	if err := loop.BindHandler(connfd, conn); err != nil {
-		l.Error("连接添加失败！", zap.Error(err))
-	}
+	return l.connectLoops[index], index
 }
 
 // ---------- other ----------
@@ -179,17 +391,63 @@ func sockAddrToString(sa unix.Sockaddr) string {
 		return fmt.Sprintf("(unknown - %T)", sa)
 	}
 }
-func parseAddr(addr string) (network, address string, port int) {
-	network = "tcp"
-	address = strings.ToLower(addr)
-	if strings.Contains(address, "://") {
-		pair := strings.Split(address, "://")
-		network = pair[0]
-		address = pair[1]
-		pair2 := strings.Split(address, ":")
-		portStr := pair2[1]
-		portInt64, _ := strconv.ParseInt(portStr, 10, 64)
-		port = int(portInt64)
+func sockAddrToTCPAddr(sa unix.Sockaddr) *net.TCPAddr {
+	switch sa := (sa).(type) {
+	case *unix.SockaddrInet4:
+		return &net.TCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port}
+	case *unix.SockaddrInet6:
+		return &net.TCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port}
+	default:
+		return nil
+	}
+}
+
+func sockAddrToIP(sa unix.Sockaddr) net.IP {
+	switch sa := (sa).(type) {
+	case *unix.SockaddrInet4:
+		return net.IP(sa.Addr[:])
+	case *unix.SockaddrInet6:
+		return net.IP(sa.Addr[:])
+	default:
+		return nil
+	}
+}
+
+// splitAddr 拆分监听地址（不含协议头）的host和端口，端口必须是0-65535
+func splitAddr(addr string) (host string, port int, err error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err = strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return "", 0, fmt.Errorf("监听地址[%s]的端口错误", addr)
+	}
+	return host, port, nil
+}
+
+// ErrIPv6Unsupported tcp监听地址只支持IPv4
+var ErrIPv6Unsupported = errors.New("tcp监听地址只支持IPv4")
+
+// parseAddr 解析tcp监听地址（可以带协议头，例如 tcp://0.0.0.0:6666），host为空或0.0.0.0则监听所有地址，只支持IPv4
+func parseAddr(addr string) (*unix.SockaddrInet4, error) {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = addr[i+len("://"):]
+	}
+	host, port, err := splitAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	sa := &unix.SockaddrInet4{Port: port}
+	if host != "" {
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			return nil, fmt.Errorf("%w：%s", ErrIPv6Unsupported, addr)
+		}
+		ip, err := net.ResolveIPAddr("ip4", host)
+		if err != nil {
+			return nil, fmt.Errorf("解析监听地址[%s]失败：%w", addr, err)
+		}
+		copy(sa.Addr[:], ip.IP.To4())
 	}
-	return
+	return sa, nil
 }
@@ -1,7 +1,12 @@
 package limnet
 
 import (
+	"sync/atomic"
+	"time"
+
+	"github.com/tangtaoit/limnet/pkg/eventloop"
 	"github.com/tangtaoit/limnet/pkg/limlog"
+	"github.com/tangtaoit/limnet/pkg/limpoller"
 	"go.uber.org/zap"
 	"golang.org/x/sys/unix"
 )
@@ -12,26 +17,56 @@ type TCPServer struct {
 	acceptFd int
 	addr     string
 	lnet     *LIMNet
+	listener *Listener
 	realAddr string // 真实连接地址
 	Stopped  chan struct{}
+
+	spareFd     int           // 预留的fd，fd耗尽时释放它来接受并关闭连接，避免连接一直堆积在队列里
+	acceptDelay time.Duration // accept失败后暂停接受连接的时间
+	closed      bool          // 监听fd是否已关闭（只在listenerLoop中访问）
+	ringID      uint64        // io_uring模式下绑定的id
+	paused      bool          // io_uring模式下是否暂停接受连接
 }
 
-// NewTCPServer 创建一个tcp服务
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// NewTCPServer 创建一个监听 Options.Addr 的tcp服务 创建失败会panic，需要处理错误请使用 LIMNet.AddListener
 func NewTCPServer(lnet *LIMNet) *TCPServer {
+	ln, err := lnet.newListener(lnet.opts.Addr, lnet.eventHandler, nil)
+	if err != nil {
+		panic(err)
+	}
+	s, err := newTCPServer(ln)
+	if err != nil {
+		panic(err)
+	}
+	ln.tcp = s
+	return s
+}
+
+// newTCPServer 创建监听器ln的tcp服务
+func newTCPServer(ln *Listener) (*TCPServer, error) {
 	s := &TCPServer{
-		Log:     limlog.NewLIMLog("TCPServer"),
-		lnet:    lnet,
-		addr:    lnet.opts.Addr,
-		Stopped: make(chan struct{}),
+		Log:      limlog.NewLIMLog("TCPServer"),
+		lnet:     ln.lnet,
+		listener: ln,
+		addr:     ln.addr,
+		Stopped:  make(chan struct{}),
+		spareFd:  -1,
 	}
 
 	// 初始化listen和添加到listenerLoop
-	s.initAndAddToLoopListen()
+	if err := s.initAndAddToLoopListen(); err != nil {
+		return nil, err
+	}
 
-	return s
+	return s, nil
 }
 
-func (s *TCPServer) initAndAddToLoopListen() {
+func (s *TCPServer) initAndAddToLoopListen() error {
 
 	// 开启tcp监听
 	// var err error
@@ -39,36 +74,77 @@ func (s *TCPServer) initAndAddToLoopListen() {
 	// if err != nil {
 	// 	panic(err)
 	// }
-	var err error
+	sockaddrInet4, err := parseAddr(s.addr)
+	if err != nil {
+		return err
+	}
 	s.acceptFd, err = unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	_, _, port := parseAddr(s.addr)
-	sockaddrInet4 := &unix.SockaddrInet4{
-		Port: port,
-		Addr: [4]byte{0, 0, 0, 0},
+	// 和net.Listen一样开启SO_REUSEADDR，避免重启时端口处于TIME_WAIT而绑定失败
+	if err = unix.SetsockoptInt(s.acceptFd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		_ = unix.Close(s.acceptFd)
+		return err
 	}
 	err = unix.Bind(s.acceptFd, sockaddrInet4)
 	if err != nil {
-		panic(err)
+		_ = unix.Close(s.acceptFd)
+		return err
 	}
 	err = unix.Listen(s.acceptFd, 100)
 	if err != nil {
-		panic(err)
+		_ = unix.Close(s.acceptFd)
+		return err
 	}
 	// f, err := s.ln.(*net.TCPListener).File()
 	// if err != nil {
 	// 	panic(err)
 	// }
 	s.realAddr = s.addr
+	if sa, err := unix.Getsockname(s.acceptFd); err == nil { // 端口为0时由系统分配
+		s.realAddr = sockAddrToString(sa)
+	}
 	if err = unix.SetNonblock(s.acceptFd, true); err != nil {
-		panic(err)
+		_ = unix.Close(s.acceptFd)
+		return err
+	}
+	// 预留一个fd用于fd耗尽时的处理，打开失败不影响监听
+	if s.spareFd, err = openSpareFd(); err != nil {
+		s.Warn("预留fd打开失败！", zap.Error(err))
+	}
+	if s.lnet.listenerLoop.IsRing() {
+		// io_uring模式使用multishot accept 收到Conn将会调用 s.HandleCompletion
+		s.ringID = uint64(atomic.AddInt64(&s.lnet.idGen, 1))
+		s.lnet.listenerLoop.BindCompletionHandler(s.ringID, s)
+		err = s.lnet.listenerLoop.SubmitAccept(s.ringID, s.acceptFd)
+	} else {
+		// 将tcp监听器放入loop 收到Conn将会调用 s.Handle
+		err = s.lnet.listenerLoop.BindHandler(s.acceptFd, s)
 	}
-	// 将tcp监听器放入loop 收到Conn将会调用 s.lnet.Handle
-	err = s.lnet.listenerLoop.BindHandler(s.acceptFd, s.lnet)
 	if err != nil {
-		panic(err)
+		if s.lnet.listenerLoop.IsRing() {
+			s.lnet.listenerLoop.DeleteCompletionHandler(s.ringID)
+		}
+		_ = unix.Close(s.acceptFd)
+		s.closeSpareFd()
+		return err
+	}
+	return nil
+}
+
+func openSpareFd() (int, error) {
+	fd, err := unix.Open("/dev/null", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	return fd, nil
+}
+
+func (s *TCPServer) closeSpareFd() {
+	if s.spareFd >= 0 {
+		_ = unix.Close(s.spareFd)
+		s.spareFd = -1
 	}
 }
 
@@ -77,18 +153,209 @@ func (s *TCPServer) GetRealAddr() string {
 	return s.addr
 }
 
-// Stop Stop
+// ---------- 实现 EventHandler ----------
+
+// Handle 处理事件通知
+func (s *TCPServer) Handle(fd int, event limpoller.Event) {
+	if event&limpoller.EventRead != 0 {
+		connfd, sa, err := unix.Accept(fd) // 接受连接的fd
+		if err != nil {
+			s.handleAcceptError(fd, err)
+			return
+		}
+		if err := unix.SetNonblock(connfd, true); err != nil { // 连接设置为不阻塞
+			_ = unix.Close(connfd)
+			s.Error("set nonblock:", zap.Error(err))
+			return
+		}
+		s.accepted(connfd, sa)
+	}
+}
+
+// HandleCompletion io_uring模式下accept完成
+func (s *TCPServer) HandleCompletion(op eventloop.Op, res int32, more bool, buf []byte) {
+	if op != eventloop.OpAccept {
+		return
+	}
+	if s.closed {
+		if res >= 0 {
+			_ = unix.Close(int(res))
+		}
+		return
+	}
+	if res >= 0 {
+		connfd := int(res)
+		sa, err := unix.Getpeername(connfd)
+		if err != nil { // 连接在accept后已断开
+			_ = unix.Close(connfd)
+		} else {
+			s.accepted(connfd, sa)
+		}
+	} else if errno := unix.Errno(-res); errno != unix.ECANCELED {
+		s.handleAcceptError(s.acceptFd, errno)
+	}
+	if !more && !s.closed && !s.paused { // multishot accept已结束，重新提交
+		if err := s.lnet.listenerLoop.SubmitAccept(s.ringID, s.acceptFd); err != nil {
+			s.Error("提交accept失败！", zap.Error(err))
+			s.listener.onError(nil, err)
+		}
+	}
+}
+
+// accepted 处理接受的连接（fd已经是非阻塞的）
+func (s *TCPServer) accepted(connfd int, sa unix.Sockaddr) {
+	s.acceptDelay = 0
+	addr := sockAddrToString(sa)
+	proxyPending := false
+	if s.lnet.opts.ProxyProtocol != ProxyProtocolOff {
+		proxyPending = s.lnet.proxyTrusted(addr)
+		if !proxyPending && s.lnet.opts.ProxyProtocol == ProxyProtocolStrict {
+			_ = unix.Close(connfd)
+			s.listener.reject(addr, RejectProxyUntrusted)
+			return
+		}
+	}
+	limitAddr := addr
+	if proxyPending { // 来自信任的代理，解析完PROXY协议头后再按真实客户端地址做访问控制和单个IP的连接数限制
+		limitAddr = ""
+	} else if !s.listener.acl().Allowed(sockAddrToIP(sa)) {
+		_ = unix.Close(connfd)
+		s.listener.reject(addr, RejectACL)
+		return
+	}
+	if reason, ok := s.lnet.limiter.acquire(limitAddr); !ok { // 超过限制，直接关闭
+		_ = unix.Close(connfd)
+		s.listener.reject(addr, reason)
+		return
+	}
+	// 处理新的连接
+	s.handleNewConnection(connfd, sa, addr, proxyPending)
+}
+
+// handleAcceptError 处理accept错误 fd耗尽时用预留fd接受并关闭一个连接，然后暂停接受连接一段时间（指数退避）
+func (s *TCPServer) handleAcceptError(fd int, err error) {
+	switch err {
+	case unix.EAGAIN, unix.EINTR, unix.ECONNABORTED: // 没有待接受的连接或者连接在accept前已断开
+		return
+	case unix.EMFILE, unix.ENFILE:
+		if s.spareFd >= 0 {
+			s.closeSpareFd()
+			if connfd, _, aerr := unix.Accept(fd); aerr == nil {
+				_ = unix.Close(connfd)
+			}
+			s.spareFd, _ = openSpareFd()
+		}
+	}
+	if s.acceptDelay == 0 {
+		s.acceptDelay = minAcceptDelay
+	} else if s.acceptDelay *= 2; s.acceptDelay > maxAcceptDelay {
+		s.acceptDelay = maxAcceptDelay
+	}
+	s.Error("accept失败，暂停接受连接", zap.Error(err), zap.Int("fd", fd), zap.Duration("delay", s.acceptDelay))
+	s.listener.onError(nil, err)
+
+	if err = s.pauseAccept(); err != nil {
+		s.Error("暂停接受连接失败！", zap.Error(err))
+		return
+	}
+	s.lnet.timingWheel.AfterFunc(s.acceptDelay, func() {
+		_ = s.lnet.listenerLoop.Trigger(s.resumeAccept)
+	})
+}
+
+// pauseAccept 暂停接受连接
+func (s *TCPServer) pauseAccept() error {
+	if s.lnet.listenerLoop.IsRing() {
+		if s.paused {
+			return nil
+		}
+		s.paused = true
+		return s.lnet.listenerLoop.SubmitCancel(s.ringID, eventloop.OpAccept)
+	}
+	return s.lnet.listenerLoop.Poller().DisableReadWrite(s.acceptFd)
+}
+
+// resumeAccept 恢复接受连接
+func (s *TCPServer) resumeAccept() error {
+	if s.closed {
+		return nil
+	}
+	if s.lnet.listenerLoop.IsRing() {
+		if !s.paused {
+			return nil
+		}
+		s.paused = false
+		return s.lnet.listenerLoop.SubmitAccept(s.ringID, s.acceptFd)
+	}
+	return s.lnet.listenerLoop.Poller().EnableRead(s.acceptFd)
+}
+
+// HandleError eventloop恢复的Handle中的panic
+func (s *TCPServer) HandleError(err error) {
+	s.listener.onError(nil, err)
+}
+
+// Close 监听fd的关闭由Stop处理
+func (s *TCPServer) Close() error {
+	return nil
+}
+
+func (s *TCPServer) handleNewConnection(connfd int, sa unix.Sockaddr, addr string, proxyPending bool) {
+	loop, loopIndex := s.lnet.nextLoop() // 获取conn的eventloop
+	clientID := atomic.AddInt64(&s.lnet.idGen, 1)
+	conn := newTCPConn(clientID, connfd, addr, loop, s.listener) // 创建一个新的连接
+	conn.loopIndex = loopIndex
+	conn.remoteAddr = sockAddrToTCPAddr(sa)
+	conn.proxyPending = proxyPending
+	if !proxyPending {
+		conn.limitAddr = addr
+	}
+	s.lnet.conns.Store(clientID, conn)
+	s.lnet.metrics.ConnAccepted(TransportTCP)
+
+	if !proxyPending { // 使用PROXY协议的连接在解析完协议头后才触发连接事件
+		s.listener.onConnect(conn) // 触发连接事件
+	}
+
+	if loop.IsRing() {
+		// io_uring只能在所属eventloop里提交操作
+		_ = loop.Trigger(func() error {
+			if !conn.connected.Get() {
+				return nil
+			}
+			loop.BindCompletionHandler(uint64(conn.id), conn)
+			return conn.submitRecv()
+		})
+		return
+	}
+	// 绑定连接fd对应的处理者
+	if err := loop.BindHandler(connfd, conn); err != nil {
+		s.Error("连接添加失败！", zap.Error(err))
+		// 连接已经登记并触发过连接事件，需要在所属eventloop里走正常的关闭流程释放
+		_ = loop.Trigger(func() error {
+			return conn.closeWith(CloseReadError, err)
+		})
+	}
+}
+
+// Stop 在监听的eventloop里关闭监听fd，关闭后Stopped会被关闭 通知eventloop失败时返回错误（Stopped不会被关闭）
 func (s *TCPServer) Stop() error {
-	s.lnet.listenerLoop.Trigger(func() error {
-		s.lnet.listenerLoop.DeleteFdInLoop(s.acceptFd)
+	return s.lnet.listenerLoop.Trigger(func() error {
+		defer close(s.Stopped) // 关闭fd失败也不能让等待Stopped的一直阻塞
+		s.closed = true
+		if s.lnet.listenerLoop.IsRing() {
+			s.lnet.listenerLoop.DeleteCompletionHandler(s.ringID)
+			_ = s.lnet.listenerLoop.SubmitCancel(s.ringID, eventloop.OpAccept)
+		} else {
+			s.lnet.listenerLoop.DeleteFdInLoop(s.acceptFd)
+		}
+		s.closeSpareFd()
 		err := unix.Close(s.acceptFd)
 		if err != nil {
 			s.Error("Quit fail", zap.Error(err))
 			return err
 		}
 		s.Info("Quit")
-		close(s.Stopped)
 		return nil
 	})
-	return nil
 }
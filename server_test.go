@@ -1,8 +1,11 @@
 package limnet
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"net"
+	"os"
 	"testing"
 	"time"
 )
@@ -15,15 +18,74 @@ func (t *TestHandler) OnConnect(c Conn) {
 }
 
 func TestServerRun(t *testing.T) {
-	s := New(&TestHandler{})
-	go s.Run()
-	time.Sleep(time.Second)
-	defer func() {
-		err := s.Stop()
+	s, addr := startServer(t, &TestHandler{})
+	dial(t, addr)
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != ErrServerStarted {
+		t.Fatalf("expect %v but got %v", ErrServerStarted, err)
+	}
+}
+
+func TestServerStartStop(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		s, err := NewServer(&TestHandler{}, WithAddr("tcp://127.0.0.1:0"))
 		if err != nil {
-			panic(err)
+			t.Fatal(err)
 		}
-	}()
+		started := make(chan error, 1)
+		go func() { started <- s.Start() }()
+		// Stop和Start同时调用，Stop等Start完成后再停止，或者先停止使Start失败
+		if err = s.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		if err = recv(t, started); err != nil && err != ErrServerStarted {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestServerWithoutWS(t *testing.T) {
+	// 没有设置websocket地址时不开启websocket服务，同一进程里可以运行多个服务
+	var servers []*LIMNet
+	for i := 0; i < 2; i++ {
+		s, addr := startServer(t, &TestHandler{})
+		if s.ws != nil {
+			t.Fatal("expect no websocket server")
+		}
+		for _, ln := range s.listeners {
+			if ln.ws != nil {
+				t.Fatalf("expect no websocket listener but got %s", ln.addr)
+			}
+		}
+		dial(t, addr)
+		servers = append(servers, s)
+	}
+	for _, s := range servers {
+		if err := s.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 设置了地址才开启，绑定失败由Start同步返回
+	s, _ := startServer(t, &TestHandler{}, WithWSAddr("127.0.0.1:17158"))
+	if s.ws == nil {
+		t.Fatal("expect websocket server")
+	}
+	conn, err := net.Dial("tcp", "127.0.0.1:17158")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	l, err := NewServer(&TestHandler{}, WithAddr("tcp://127.0.0.1:0"), WithWSAddr("127.0.0.1:17158"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Start(); err == nil {
+		t.Fatal("expect ws listen error")
+	}
+	_ = l.Stop()
 }
 
 func BenchmarkSendMessage(b *testing.B) {
@@ -44,3 +106,99 @@ func BenchmarkSendMessage(b *testing.B) {
 		math.Abs(float64(i))
 	}
 }
+
+func TestParseAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		ip   [4]byte
+		port int
+		err  bool
+	}{
+		{addr: "tcp://127.0.0.1:17773", ip: [4]byte{127, 0, 0, 1}, port: 17773},
+		{addr: "127.0.0.1:17773", ip: [4]byte{127, 0, 0, 1}, port: 17773},
+		{addr: "tcp://:17773", port: 17773},
+		{addr: "tcp://0.0.0.0:0"},
+		{addr: "tcp://localhost:17773", ip: [4]byte{127, 0, 0, 1}, port: 17773},
+		{addr: "tcp://localhost", err: true},
+		{addr: "tcp://127.0.0.1:abc", err: true},
+		{addr: "tcp://127.0.0.1:70000", err: true},
+		{addr: "tcp://[::1]:17773", err: true},
+	}
+	for _, tt := range tests {
+		sa, err := parseAddr(tt.addr)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expect error", tt.addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.addr, err)
+			continue
+		}
+		if sa.Addr != tt.ip || sa.Port != tt.port {
+			t.Errorf("%s: expect %v:%d but got %v:%d", tt.addr, tt.ip, tt.port, sa.Addr, sa.Port)
+		}
+	}
+
+	if _, err := NewServer(&TestHandler{}, WithAddr("tcp://localhost")); err == nil {
+		t.Fatal("expect error")
+	}
+	if _, err := NewServer(&TestHandler{}, WithAddr("tcp://[::1]:17773")); !errors.Is(err, ErrIPv6Unsupported) {
+		t.Fatalf("expect %v but got %v", ErrIPv6Unsupported, err)
+	}
+	if opts := NewOption(); opts.Addr != "tcp://0.0.0.0:6666" {
+		t.Fatalf("expect default addr listening on all interfaces but got %s", opts.Addr)
+	}
+}
+
+// openFds 进程打开的fd数量，不支持的系统返回-1
+func openFds() int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(fds)
+}
+
+func TestServerStartFail(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:17112")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	before := openFds()
+	l, err := NewServer(&TestHandler{}, WithAddr("tcp://127.0.0.1:17111"), WithWSAddr("127.0.0.1:17112"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Start(); err == nil {
+		t.Fatal("expect ws listen error")
+	}
+	if err = l.Start(); err != ErrServerStarted {
+		t.Fatalf("expect ErrServerStarted but got %v", err)
+	}
+	if err = l.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	// 启动失败后监听fd和eventloop都已释放
+	if after := openFds(); after != before {
+		t.Fatalf("expect %d fds but got %d", before, after)
+	}
+
+	// 没有启动过的服务也可以停止
+	l, err = NewServer(&TestHandler{}, WithAddr("tcp://127.0.0.1:17111"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if after := openFds(); after != before {
+		t.Fatalf("expect %d fds but got %d", before, after)
+	}
+}
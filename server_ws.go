@@ -2,8 +2,11 @@ package limnet
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"net"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -12,64 +15,141 @@ import (
 	"go.uber.org/zap"
 )
 
+// wsWriteBufferPool websocket连接只在写消息期间占用写buffer，空闲连接不持有
+var wsWriteBufferPool = &sync.Pool{}
+
 // WSServer websocket服务
 type WSServer struct {
 	limlog.Log
-	lnet *LIMNet
-	srv  *http.Server
+	lnet     *LIMNet
+	listener *Listener
+	srv      *http.Server
 }
 
-// NewWSServer 创建一个websocket服务
+// NewWSServer 创建默认websocket监听器（ Options.WSAddr ）的websocket服务
 func NewWSServer(lnet *LIMNet) *WSServer {
+	return newWSServer(lnet.defaultListener(TransportWS))
+}
+
+// newWSServer 创建监听器ln的websocket服务
+func newWSServer(ln *Listener) *WSServer {
 	return &WSServer{
-		Log:  limlog.NewLIMLog("WSServer"),
-		lnet: lnet,
+		Log:      limlog.NewLIMLog("WSServer"),
+		lnet:     ln.lnet,
+		listener: ln,
 	}
 }
 
-// Start Start
-func (s *WSServer) Start() {
+// Start 开始监听，监听失败直接返回错误
+func (s *WSServer) Start() error {
+	tlsConfig := s.listener.opts.TLSConfig
+	if tlsConfig == nil && s == s.lnet.ws && s.lnet.opts.SSLOn {
+		cert, err := tls.LoadX509KeyPair(s.lnet.opts.SSLCertificate, s.lnet.opts.SSLCertificateKey)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
 	mux := http.NewServeMux()
-	s.srv = &http.Server{Addr: s.lnet.opts.WSAddr, Handler: mux}
+	s.srv = &http.Server{Addr: s.listener.addr, Handler: mux, TLSConfig: tlsConfig}
 	mux.HandleFunc("/", s.server)
+	if s == s.lnet.ws { // 指标和管理接口只挂载到默认的websocket服务上
+		if s.lnet.opts.MetricsPath != "" {
+			mux.Handle(s.lnet.opts.MetricsPath, s.withACL(s.lnet.MetricsHandler()))
+		}
+		if s.lnet.opts.AdminOn && s.lnet.opts.AdminAddr == "" { // 管理接口挂载到websocket服务上
+			s.lnet.admin.mount(mux, s.withACL)
+		}
+	}
+	ln, err := net.Listen("tcp", s.listener.addr)
+	if err != nil {
+		return err
+	}
 	go func() {
 		var err error
-		if s.lnet.opts.SSLOn {
-			err = s.srv.ListenAndServeTLS(s.lnet.opts.SSLCertificate, s.lnet.opts.SSLCertificateKey)
+		if tlsConfig != nil {
+			err = s.srv.ServeTLS(ln, "", "")
 		} else {
-			err = s.srv.ListenAndServe()
+			err = s.srv.Serve(ln)
 		}
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			panic(err)
+			s.Error("websocket服务异常退出！", zap.Error(err))
 		}
-		s.Debug("Start")
 	}()
-
+	s.Debug("Start", zap.String("addr", s.listener.addr))
+	return nil
 }
 
 // Stop Stop
 func (s *WSServer) Stop() error {
+	if s.srv == nil {
+		return nil
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	return s.srv.Shutdown(ctx)
 }
 
+// withACL 挂载到websocket服务上的指标和管理接口和websocket连接一样经过监听器的ip访问控制
+func (s *WSServer) withACL(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := r.RemoteAddr
+		if realAddr := s.lnet.forwardedAddr(r); realAddr != "" {
+			addr = realAddr
+		}
+		if !s.listener.acl().AllowedAddr(addr) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (s *WSServer) server(w http.ResponseWriter, r *http.Request) {
+	addr := r.RemoteAddr
+	if s.lnet.opts.ProxyProtocol != ProxyProtocolOff {
+		if realAddr := s.lnet.forwardedAddr(r); realAddr != "" {
+			addr = realAddr
+		} else if s.lnet.opts.ProxyProtocol == ProxyProtocolStrict {
+			w.Header().Set("Connection", "close")
+			http.Error(w, "forbidden", http.StatusForbidden)
+			s.listener.reject(r.RemoteAddr, RejectProxyUntrusted)
+			return
+		}
+	}
+	// 访问控制和单个IP的连接数都按真实客户端地址
+	if !s.listener.acl().AllowedAddr(addr) {
+		w.Header().Set("Connection", "close")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		s.listener.reject(addr, RejectACL)
+		return
+	}
+	if reason, ok := s.lnet.limiter.acquire(addr); !ok { // 超过限制，升级前直接拒绝
+		w.Header().Set("Connection", "close")
+		http.Error(w, string(reason), http.StatusServiceUnavailable)
+		s.listener.reject(addr, reason)
+		return
+	}
 	conn, err := (&websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
 			return true
 		},
+		WriteBufferPool: wsWriteBufferPool,
 	}).Upgrade(w, r, nil)
 	if err != nil {
+		s.lnet.limiter.release(addr)
 		http.NotFound(w, r)
 		s.Error("conn creat err", zap.Error(err))
 		return
 	}
-	s.handleNewConnection(conn)
+	s.handleNewConnection(conn, addr)
 }
 
-func (s *WSServer) handleNewConnection(conn *websocket.Conn) {
+func (s *WSServer) handleNewConnection(conn *websocket.Conn, addr string) {
 	clientID := atomic.AddInt64(&s.lnet.idGen, 1)
-	wsconn := NewWSConn(clientID, conn, s.lnet) // 创建一个新的连接
-	s.lnet.eventHandler.OnConnect(wsconn)
+	wsconn := newWSConn(clientID, conn, addr, s.listener) // 创建一个新的连接
+	s.lnet.conns.Store(clientID, wsconn)
+	s.lnet.metrics.ConnAccepted(TransportWS)
+	s.listener.onConnect(wsconn)
+	go wsconn.msgLoop() // OnConnect之后才开始读取
 }
@@ -0,0 +1,164 @@
+package limnet
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// ErrSpliceUnsupported 不支持splice转发（非linux、非TCP连接或者io_uring模式）
+var ErrSpliceUnsupported = errors.New("不支持splice转发")
+
+// Splice 把src读到的数据用splice(2)经管道直接转发给dst，数据不经过用户空间
+// 转发开始后src不再触发OnPacket，src未解析的数据会先写给dst；双向转发需要再调用Splice(dst, src)
+// 一个连接只能有一个转发来源，dst关闭时src也会关闭
+// src的对端关闭写方向时和普通连接一样按 HalfCloseHandler 处理，可以在OnPeerHalfClose里调用dst.CloseWrite()把半关闭传给dst
+func Splice(src, dst Conn) error {
+	s, ok := src.(*TCPConn)
+	if !ok {
+		return ErrSpliceUnsupported
+	}
+	d, ok := dst.(*TCPConn)
+	if !ok || s == d || s.loop.IsRing() || d.loop.IsRing() {
+		return ErrSpliceUnsupported
+	}
+	if !s.connected.Get() || !d.connected.Get() {
+		return ErrConnectionClosed
+	}
+	sp, err := newSplicer(s, d)
+	if err != nil {
+		return err
+	}
+	err = s.loop.Trigger(func() error {
+		if !s.connected.Get() || s.spliceTo != nil {
+			sp.close()
+			return nil
+		}
+		var unread []byte
+		if n := s.inboundBuffer.Length(); n > 0 {
+			head, tail := s.inboundBuffer.LazyReadAll()
+			unread = append(append(make([]byte, 0, n), head...), tail...)
+			s.inboundBuffer.Reset()
+			s.releaseInbound()
+		}
+		// 先在dst的eventloop里绑定，之后的转发任务都排在它后面
+		if err := d.loop.Trigger(func() error {
+			if !d.connected.Get() || d.spliceFrom != nil {
+				sp.close()
+				return nil
+			}
+			d.spliceFrom = sp
+			if len(unread) > 0 {
+				d.write(unread)
+			}
+			return nil
+		}); err != nil {
+			sp.close()
+			return err
+		}
+		s.spliceTo = sp
+		return nil
+	})
+	if err != nil {
+		sp.close()
+	}
+	return err
+}
+
+// handleSplice src可读，把数据splice到管道里并暂停读取，由dst的eventloop转发
+func (c *TCPConn) handleSplice() error {
+	sp := c.spliceTo
+	n, err := sp.fill(c.fd)
+	if err != nil {
+		if err == unix.EAGAIN {
+			return nil
+		}
+		if err != ErrConnectionClosed {
+			c.listener.onError(c, err)
+		}
+		return c.closeWith(CloseReadError, err)
+	}
+	if n == 0 { // 对端关闭了写方向，dst转发完管道里剩余的数据后结束转发，src按半关闭处理
+		c.spliceTo = nil
+		sp.srcDone()
+		dst := sp.dst
+		if err = dst.loop.Trigger(dst.drainSplice); err != nil {
+			c.Error("通知转发结束失败！", zap.Error(err))
+		}
+		return c.handlePeerEOF()
+	}
+	c.lnet.metrics.BytesIn(TransportTCP, n)
+	c.splicePaused = true
+	if c.writePending() {
+		err = c.loop.Poller().EnableWrite(c.fd)
+	} else {
+		err = c.loop.Poller().DisableReadWrite(c.fd)
+	}
+	if err != nil {
+		c.Error("暂停读取失败！", zap.Error(err))
+	}
+	dst := sp.dst
+	return dst.loop.Trigger(dst.drainSplice)
+}
+
+// resumeSplice 管道里的数据已转发完，恢复读取
+func (c *TCPConn) resumeSplice() error {
+	if !c.connected.Get() || !c.splicePaused {
+		return nil
+	}
+	c.splicePaused = false
+	if c.throttled {
+		return nil
+	}
+	if !c.writePending() {
+		return c.loop.Poller().EnableRead(c.fd)
+	}
+	return c.loop.Poller().EnableReadWrite(c.fd)
+}
+
+// drainSplice 把管道里的数据splice到连接，需要在输出buffer和文件段写完之后
+func (c *TCPConn) drainSplice() error {
+	sp := c.spliceFrom
+	if !c.connected.Get() || sp == nil {
+		return nil
+	}
+	if !c.outboundBuffer.IsEmpty() || len(c.files) > 0 { // handleWrite写完后会继续转发
+		return c.enableWrite()
+	}
+	n, pending, err := sp.drain(c.fd)
+	if n > 0 {
+		c.lnet.metrics.BytesOut(TransportTCP, n)
+	}
+	if err != nil {
+		if err == unix.EAGAIN {
+			c.lnet.metrics.EAGAIN(TransportTCP)
+			return c.enableWrite()
+		}
+		c.listener.onError(c, err)
+		return c.closeWith(CloseWriteError, err)
+	}
+	if pending {
+		return c.enableWrite()
+	}
+	if sp.finished() { // 转发来源已关闭且数据已转发完
+		sp.close()
+		c.spliceFrom = nil
+		return nil
+	}
+	src := sp.src
+	return src.loop.Trigger(src.resumeSplice)
+}
+
+// stopSplice 连接关闭时结束转发
+func (c *TCPConn) stopSplice() {
+	if sp := c.spliceTo; sp != nil { // dst继续转发管道里剩余的数据
+		sp.srcDone()
+		dst := sp.dst
+		_ = dst.loop.Trigger(dst.drainSplice)
+	}
+	if sp := c.spliceFrom; sp != nil { // 不再接收转发，关闭转发来源
+		sp.close()
+		_ = sp.src.Close()
+	}
+}
@@ -0,0 +1,94 @@
+// +build linux
+
+package limnet
+
+import (
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	spliceChunk = 1 << 16 // 一次最多splice的字节数（管道默认容量）
+	spliceFlags = unix.SPLICE_F_MOVE | unix.SPLICE_F_NONBLOCK
+)
+
+// splicer src到dst的转发管道，src和dst可能在不同的eventloop，管道fd由锁保护
+type splicer struct {
+	src, dst  *TCPConn
+	mu        sync.Mutex
+	r, w      int  // 管道的读写fd，关闭后为-1
+	buffered  int  // 管道里还没转发的字节数
+	srcClosed bool // src是否已关闭
+}
+
+func newSplicer(src, dst *TCPConn) (*splicer, error) {
+	var p [2]int
+	if err := unix.Pipe2(p[:], unix.O_NONBLOCK|unix.O_CLOEXEC); err != nil {
+		return nil, err
+	}
+	return &splicer{src: src, dst: dst, r: p[0], w: p[1]}, nil
+}
+
+// fill 把fd里的数据splice到管道，返回0表示对端已关闭
+func (sp *splicer) fill(fd int) (int, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.w < 0 {
+		return 0, ErrConnectionClosed
+	}
+	n, err := unix.Splice(fd, nil, sp.w, nil, spliceChunk, spliceFlags)
+	if err != nil {
+		return 0, err
+	}
+	sp.buffered += int(n)
+	return int(n), nil
+}
+
+// drain 把管道里的数据splice到fd，pending表示还有数据没转发
+func (sp *splicer) drain(fd int) (written int, pending bool, err error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	for sp.r >= 0 && sp.buffered > 0 {
+		n, err := unix.Splice(sp.r, nil, fd, nil, sp.buffered, spliceFlags)
+		if err != nil {
+			return written, true, err
+		}
+		if n == 0 {
+			break
+		}
+		sp.buffered -= int(n)
+		written += int(n)
+	}
+	return written, sp.r >= 0 && sp.buffered > 0, nil
+}
+
+func (sp *splicer) pending() bool {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.r >= 0 && sp.buffered > 0
+}
+
+func (sp *splicer) srcDone() {
+	sp.mu.Lock()
+	sp.srcClosed = true
+	sp.mu.Unlock()
+}
+
+// finished src已关闭且管道里的数据已转发完
+func (sp *splicer) finished() bool {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.srcClosed && sp.buffered == 0
+}
+
+func (sp *splicer) close() {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.r >= 0 {
+		_ = unix.Close(sp.r)
+		_ = unix.Close(sp.w)
+		sp.r, sp.w = -1, -1
+	}
+	sp.buffered = 0
+}
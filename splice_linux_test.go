@@ -0,0 +1,94 @@
+// +build linux
+
+package limnet
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type spliceHandler struct {
+	DefaultEventHandler
+	mu     sync.Mutex
+	conns  []Conn
+	closed chan CloseReason
+	errs   chan error
+}
+
+func (h *spliceHandler) OnConnect(c Conn) {
+	h.mu.Lock()
+	h.conns = append(h.conns, c)
+	if len(h.conns) == 2 { // 第一个连接的数据转发给第二个连接
+		h.errs <- Splice(h.conns[0], h.conns[1])
+	}
+	h.mu.Unlock()
+}
+
+func (h *spliceHandler) OnClose(c Conn) {
+	h.closed <- c.CloseReason()
+}
+
+func (h *spliceHandler) dst() Conn {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.conns[1]
+}
+
+// halfCloseSpliceHandler 把src的半关闭传给dst
+type halfCloseSpliceHandler struct {
+	spliceHandler
+}
+
+func (h *halfCloseSpliceHandler) OnPeerHalfClose(c Conn) {
+	_ = h.dst().CloseWrite()
+}
+
+func testSplice(t *testing.T, h EventHandler, sh *spliceHandler) *net.TCPConn {
+	_, addr := startServer(t, h)
+	src := dial(t, addr)
+	dst := dial(t, addr)
+	if err := recv(t, sh.errs); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), 100000) // 大于管道容量
+	go func() {
+		_, _ = src.Write(data)
+		_ = src.CloseWrite()
+	}()
+	got := make([]byte, len(data))
+	_ = dst.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := io.ReadFull(dst, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("spliced data mismatch")
+	}
+	return dst
+}
+
+func TestSplice(t *testing.T) {
+	h := &spliceHandler{closed: make(chan CloseReason, 2), errs: make(chan error, 1)}
+	testSplice(t, h, h)
+	// 没有实现HalfCloseHandler，src的对端关闭写方向后关闭src
+	if reason := recv(t, h.closed); reason != ClosePeerEOF {
+		t.Fatalf("expect %s but got %s", ClosePeerEOF, reason)
+	}
+}
+
+func TestSplice_HalfClose(t *testing.T) {
+	h := &halfCloseSpliceHandler{spliceHandler{closed: make(chan CloseReason, 2), errs: make(chan error, 1)}}
+	dst := testSplice(t, h, &h.spliceHandler)
+	if _, err := dst.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect EOF on dst but got %v", err)
+	}
+	// 半关闭传给了dst，src保持连接
+	select {
+	case reason := <-h.closed:
+		t.Fatalf("expect src kept open but closed: %s", reason)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,24 @@
+// +build !linux
+
+package limnet
+
+// splicer splice(2)只支持linux
+type splicer struct {
+	src, dst *TCPConn
+}
+
+func newSplicer(src, dst *TCPConn) (*splicer, error) {
+	return nil, ErrSpliceUnsupported
+}
+
+func (sp *splicer) fill(fd int) (int, error) { return 0, ErrSpliceUnsupported }
+
+func (sp *splicer) drain(fd int) (int, bool, error) { return 0, false, ErrSpliceUnsupported }
+
+func (sp *splicer) pending() bool { return false }
+
+func (sp *splicer) srcDone() {}
+
+func (sp *splicer) finished() bool { return true }
+
+func (sp *splicer) close() {}
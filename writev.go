@@ -0,0 +1,38 @@
+package limnet
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// iovMax 一次writev最多写出的buffer数量（IOV_MAX）
+const iovMax = 1024
+
+// writev 把多个buffer用一次系统调用写出，iovs用于复用iovec避免每次分配
+func writev(fd int, bufs [][]byte, iovs *[]unix.Iovec) (int, error) {
+	iov := (*iovs)[:0]
+	for _, buf := range bufs {
+		if len(buf) == 0 {
+			continue
+		}
+		v := unix.Iovec{Base: &buf[0]}
+		v.SetLen(len(buf))
+		iov = append(iov, v)
+		if len(iov) == iovMax {
+			break
+		}
+	}
+	*iovs = iov
+	if len(iov) == 0 {
+		return 0, nil
+	}
+	n, _, errno := unix.Syscall(unix.SYS_WRITEV, uintptr(fd), uintptr(unsafe.Pointer(&iov[0])), uintptr(len(iov)))
+	for i := range iov { // 不持有buffer的引用
+		iov[i].Base = nil
+	}
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}